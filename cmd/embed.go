@@ -5,9 +5,14 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"ruborag/internal/chunker"
 	"ruborag/internal/db"
 	"ruborag/internal/embedding"
+	"ruborag/internal/parser"
+	"ruborag/internal/tokenizer"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/spf13/cobra"
 )
@@ -15,11 +20,34 @@ import (
 var writeToIndex bool
 var useChunking bool
 var chunkSize int
+var chunkStrategy string
+var chunkOverlap string
+var chunkTokens int
+var tokenVocab string
+var chunkMinSize int
+var semanticWindow int
+var breakpointPercentile float64
+var dryRun bool
+
+// semantic is the chunker when --chunker semantic is given, kept apart
+// from the overlap wrapper for --dry-run
+var semantic *chunker.Semantic
+
+var parentStrategy string
+var parentSize int
+
+// parentSplit cuts the parent passages of --parents, which split then
+// divides into the chunks that are embedded
+var parentSplit chunker.Chunker
+
+// tokenCounter counts the tokens of each chunk against --chunk-tokens; it
+// is nil when chunks are sized in characters
+var tokenCounter tokenizer.Counter
 
 var embedCmd = &cobra.Command{
 	Use:   "embed [-w|-c] <input_path>...",
 	Short: "Generate vector embeddings of one or more files",
-	Long: `The embed command reads parsed text files (produced by ruborag parse)
+	Long: `The embed command reads parsed text or Markdown files (produced by ruborag parse)
 and computes vector embeddings for each file. When the -w flag is provided,
 the embeddings are stored in a local SQLite database (ruborag.db) for later retrieval.
 
@@ -28,16 +56,92 @@ using the -c flag, files are split into smaller chunks and each chunk is
 embedded independently.
 
 When the -w flag is provided, embeddings are stored in a local SQLite database
-(ruborag.db) for later retrieval by the search and ask commands. Otherwise,
+(ruborag.db) for later retrieval by the search command. Otherwise,
 embedding information is printed to stdout.
 
 Chunking is recommended for large files, as it improves retrieval quality
 and avoids model input size limits.
 
+The --chunker flag selects how files are split (giving it enables
+chunking):
+  fixed      cut every --chunk-size characters, even mid-word (default)
+  sentence   pack whole sentences into chunks of up to --chunk-size
+  paragraph  pack whole paragraphs (separated by blank lines) into chunks;
+             text output of ruborag parse has none and is packed by sentence
+  section    one chunk per Markdown section, from a heading to the next;
+             use with parse --format markdown
+  code       pack paragraphs, keeping every fenced code block whole with
+             the paragraph that introduces it; listings longer than
+             --chunk-size are split at blank lines or fn/impl items,
+             each piece embedded inside the listing's fence
+  hierarchical
+             split like section, and embed each chunk after a breadcrumb
+             of its chapter and headings ("Chapter 4 › What Is Ownership?
+             › Variable Scope"); the index keeps the raw chunk text and
+             the breadcrumb apart
+  semantic   split where the topic shifts: every sentence is embedded, and
+             a chunk ends where the similarity of the --semantic-window
+             sentences before and after a boundary falls below the
+             --breakpoint-percentile of the file's similarities, once the
+             chunk has --chunk-min characters, or before it would exceed
+             --chunk-size; this costs an embedding request per 100
+             sentences on top of the chunks
+Units longer than --chunk-size are split with the next finer strategy.
+
+With --parents, files are first split into parent passages with that
+strategy (e.g. section, or hierarchical for breadcrumbs), of up to
+--parent-size characters or, with --chunk-tokens, tokens, and each passage
+is split into chunks with --chunker. Only the chunks are embedded; the
+passages are stored alongside, each chunk linked to its own, so
+"ruborag search --expand parent" can match small chunks precisely and show
+the larger passage around them.
+
+--dry-run prints where each file would be split, with the offset and start
+of every chunk, instead of embedding it. For the semantic chunker it
+lists the proposed boundaries with their similarity, which helps tune
+--breakpoint-percentile; the sentences are still embedded.
+
+With --chunk-overlap, every chunk after the first also repeats the end of
+the chunk before it, so a sentence cut by a boundary is whole in at least
+one chunk: a number of characters ("200", moved to the next word start) or
+of sentences ("2s"). The overlap is stored with each chunk, which lets
+search report adjacent overlapping hits once.
+
+Models limit their input in tokens rather than characters, and code takes
+far more tokens per character than prose. --chunk-tokens sizes chunks in
+tokens instead of --chunk-size characters (giving it enables chunking);
+the embedding model, gemini-embedding-001, reads at most 2048 tokens.
+Tokens are estimated from word lengths and punctuation, erring on the
+high side, unless --vocab names a BPE vocabulary file in tiktoken's
+format, such as cl100k_base.tiktoken, to count them exactly for that
+encoding. The size covers all the text that is embedded: breadcrumbs,
+fences around pieces of a listing and the --chunk-overlap prefix are
+counted with the chunk, and a chunk that still comes out larger is an
+error rather than input the model would cut short.
+
 Options:
   -w, --write            Store embeddings in SQLite index
   -c, --chunk            Enable chunking before embedding
       --chunk-size int   Size of each chunk in characters (default: 1000)
+      --chunker string   Chunking strategy (default: fixed)
+      --chunk-overlap    Characters ("200") or sentences ("2s") repeated
+                         from the previous chunk (default: none)
+      --chunk-tokens int Size of each chunk in tokens, instead of
+                         --chunk-size (default: off)
+      --vocab string     tiktoken BPE vocabulary file used to count tokens
+                         (default: estimate)
+      --chunk-min int    Smallest semantic chunk that ends at a topic shift
+                         (default: a quarter of the chunk size)
+      --semantic-window int
+                         Sentences compared on each side of a boundary
+                         (default: 2)
+      --breakpoint-percentile float
+                         Percentile of similarities below which the
+                         semantic chunker splits (default: 10)
+      --parents string   Chunking strategy for parent passages stored with
+                         their chunks (default: none)
+      --parent-size int  Size of each parent passage (default: 4000)
+      --dry-run          Print chunk boundaries instead of embedding
 
 Examples:
 
@@ -55,16 +159,105 @@ Examples:
 
   # Embed all parsed files in a directory with chunking and storage
   ruborag embed -w -c parsed/
+
+  # Embed sentence-packed chunks that repeat the previous chunk's last sentence
+  ruborag embed -w --chunker sentence --chunk-overlap 1s parsed/
+
+  # Embed one chunk per section of Markdown output
+  ruborag embed -w --chunker section parsed/
+
+  # Embed sections with their chapter and heading breadcrumbs
+  ruborag embed -w --chunker hierarchical parsed/
+
+  # Embed paragraph-packed chunks of at most 512 tokens
+  ruborag embed -w --chunker paragraph --chunk-tokens 512 parsed/
+
+  # Count the tokens with a BPE vocabulary instead of estimating them
+  ruborag embed -w --chunk-tokens 512 --vocab cl100k_base.tiktoken parsed/
+
+  # Embed small sentence chunks, stored with the sections they come from
+  ruborag embed -w --parents hierarchical --chunker sentence --chunk-size 300 parsed/
+
+  # Preview where the semantic chunker would split a chapter
+  ruborag embed --chunker semantic --dry-run parsed/ch04-01-what-is-ownership.md
+
+  # Embed semantic chunks, splitting at the sharpest 5% of topic shifts
+  ruborag embed -w --chunker semantic --breakpoint-percentile 5 parsed/
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) == 0 {
 			log.Fatal("no input files or directories provided")
 		}
 
+		strategy, err := chunker.ParseStrategy(chunkStrategy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		overlap, err := chunker.ParseOverlap(chunkOverlap)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if cmd.Flags().Changed("chunk-tokens") && cmd.Flags().Changed("chunk-size") {
+			log.Fatal("--chunk-tokens and --chunk-size cannot be used together")
+		}
+		if tokenVocab != "" && !cmd.Flags().Changed("chunk-tokens") {
+			log.Fatal("--vocab requires --chunk-tokens")
+		}
+		if cmd.Flags().Changed("chunker") || overlap.Size > 0 || cmd.Flags().Changed("chunk-tokens") || parentStrategy != "" {
+			useChunking = true
+		}
+		var split chunker.Chunker
+		if useChunking {
+			size := chunkSize
+			var counter tokenizer.Counter
+			if cmd.Flags().Changed("chunk-tokens") {
+				size = chunkTokens
+				counter, err = tokenizer.Load(tokenVocab)
+				if err != nil {
+					log.Fatalf("failed to load vocabulary: %v", err)
+				}
+				tokenCounter = counter
+			}
+			if strategy == chunker.StrategySemantic {
+				if size <= 0 {
+					log.Fatalf("chunk size must be positive, got %d", size)
+				}
+				minSize := chunkMinSize
+				if !cmd.Flags().Changed("chunk-min") {
+					minSize = size / 4
+				}
+				semantic = &chunker.Semantic{
+					Embed:      embedding.EmbedBatch,
+					Window:     semanticWindow,
+					Percentile: breakpointPercentile,
+					MinSize:    minSize,
+					MaxSize:    size,
+					Counter:    counter,
+				}
+				split = semantic
+			} else {
+				split, err = chunker.NewCounted(strategy, size, counter)
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+			split = chunker.WithOverlap(split, overlap)
+
+			if parentStrategy != "" {
+				strategy, err := chunker.ParseStrategy(parentStrategy)
+				if err != nil {
+					log.Fatal(err)
+				}
+				parentSplit, err = chunker.NewCounted(strategy, parentSize, counter)
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+		}
+
 		var database *db.DB
-		var err error
 
-		if writeToIndex {
+		if writeToIndex && !dryRun {
 			database, err = db.Open(db.DefaultDBName)
 			if err != nil {
 				log.Fatalf("failed to open database: %v", err)
@@ -73,7 +266,7 @@ Examples:
 		}
 
 		for _, inputPath := range args {
-			if err := processEmbedPath(inputPath, database); err != nil {
+			if err := processEmbedPath(inputPath, split, database); err != nil {
 				log.Fatalf("embedding failed for %s: %v", inputPath, err)
 			}
 		}
@@ -81,7 +274,7 @@ Examples:
 }
 
 // handles a single file or directory
-func processEmbedPath(path string, database *db.DB) error {
+func processEmbedPath(path string, split chunker.Chunker, database *db.DB) error {
 	info, err := os.Stat(path)
 	if err != nil {
 		return err
@@ -95,66 +288,135 @@ func processEmbedPath(path string, database *db.DB) error {
 			if info.IsDir() {
 				return nil
 			}
-			if strings.HasSuffix(info.Name(), ".txt") {
-				return embedFile(p, database)
+			if strings.HasSuffix(info.Name(), ".txt") || strings.HasSuffix(info.Name(), ".md") {
+				return embedFile(p, split, database)
 			}
 			return nil
 		})
 	}
 
-	return embedFile(path, database)
+	return embedFile(path, split, database)
 }
 
-// generates an embedding for a file and writes it to DB if requested
-func embedFile(path string, database *db.DB) error {
+// generates an embedding for a file and writes it to DB if requested;
+// split is nil when the file is embedded as a single chunk
+func embedFile(path string, split chunker.Chunker, database *db.DB) error {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return fmt.Errorf("failed to read file %s: %w", path, err)
 	}
 	text := string(data)
 
-	var chunks []string
-	if useChunking {
-		chunks = splitTextIntoChunks(text, chunkSize)
-	} else {
-		chunks = []string{text} // single chunk = whole file
+	sourceFile := filepath.Base(path)
+	// leave room for the chapter that addChapterBreadcrumb puts first
+	if label := chapterLabel(sourceFile); label != "" && split != nil && hasBreadcrumbs() {
+		split = chunker.Reserve(split, label+chunker.BreadcrumbSeparator)
 	}
 
-	sourceFile := filepath.Base(path)
+	if dryRun {
+		return previewChunks(path, text, split)
+	}
 
-	for i, chunk := range chunks {
+	var chunks []chunker.Chunk
+	// with --parents, parentOf[i] is the index in parents of the passage
+	// chunk i was cut from
+	var parents []chunker.Chunk
+	var parentOf []int
+	if split != nil && parentSplit != nil {
+		families, err := chunker.Nest(text, parentSplit, split)
+		if err != nil {
+			return fmt.Errorf("failed to chunk %s: %w", path, err)
+		}
+		for i, f := range families {
+			parents = append(parents, f.Parent)
+			for _, c := range f.Children {
+				chunks = append(chunks, c)
+				parentOf = append(parentOf, i)
+			}
+		}
+	} else if split != nil {
+		chunks, err = split.Chunk(text)
+		if err != nil {
+			return fmt.Errorf("failed to chunk %s: %w", path, err)
+		}
+	} else {
+		chunks = []chunker.Chunk{{Text: text, End: len(text)}} // single chunk = whole file
+	}
 
-		if database != nil {
-			exists, err := database.EmbeddingExists(sourceFile, i)
+	if split != nil {
+		addChapterBreadcrumb(chunks, sourceFile)
+		addChapterBreadcrumb(parents, sourceFile)
+	}
+	if tokenCounter != nil {
+		for i, chunk := range chunks {
+			if n := tokenCounter.Count(chunk.Embedded()); n > chunkTokens {
+				return fmt.Errorf("chunk %d of %s is %d tokens with its breadcrumb and overlap, over --chunk-tokens %d", i, path, n, chunkTokens)
+			}
+		}
+	}
+
+	// chunks stored by an earlier run are skipped
+	var exists []bool
+	if database != nil {
+		exists = make([]bool, len(chunks))
+		for i := range chunks {
+			exists[i], err = database.EmbeddingExists(sourceFile, i)
 			if err != nil {
 				return fmt.Errorf("failed to check existing embedding for %s (chunk %d): %w", path, i, err)
 			}
 
 			// Non-chunked mode → skip entire file immediately
-			if exists && !useChunking {
+			if exists[i] && split == nil {
 				fmt.Printf("skipping %s (already embedded)\n", path)
 				return nil
 			}
+		}
+	}
 
-			// Chunked mode → skip only this chunk
-			if exists {
-				fmt.Printf("skipping %s (chunk %d already embedded)\n", path, i)
+	// parents are not embedded; they are stored first so their chunks can
+	// link to them. A parent whose chunks are all stored already is left
+	// as it is, so it still matches them.
+	parentIDs := make([]int64, len(parents))
+	if database != nil {
+		for i, p := range parents {
+			if allStored(parentOf, exists, i) {
 				continue
 			}
+			parentIDs[i], err = database.InsertParent(sourceFile, i, p.Text, p.Breadcrumb)
+			if err != nil {
+				return fmt.Errorf("failed to store parent %d of %s: %w", i, path, err)
+			}
+		}
+	}
+
+	for i, chunk := range chunks {
+		// Chunked mode → skip only this chunk
+		if exists != nil && exists[i] {
+			fmt.Printf("skipping %s (chunk %d already embedded)\n", path, i)
+			continue
 		}
 
-		vec, err := embedding.EmbedChunk(chunk)
+		vec, err := embedding.EmbedChunk(chunk.Embedded())
 		if err != nil {
 			return fmt.Errorf("embedding error for chunk %d of %s: %w", i, path, err)
 		}
 
 		if database != nil {
-			if err := database.InsertEmbedding(
-				sourceFile,
-				i, // chunk_index
-				chunk,
-				vec,
-			); err != nil {
+			var parentID int64
+			if parentOf != nil {
+				parentID = parentIDs[parentOf[i]]
+			}
+			if err := database.InsertEmbedding(db.Embedding{
+				SourceFile: sourceFile,
+				ChunkIndex: i,
+				Content:    chunk.Text,
+				Start:      chunk.Start,
+				End:        chunk.End,
+				Breadcrumb: chunk.Breadcrumb,
+				Overlap:    chunk.Overlap,
+				ParentID:   parentID,
+				Vector:     vec,
+			}); err != nil {
 				return fmt.Errorf("failed to insert embedding for chunk %d of %s: %w", i, path, err)
 			}
 
@@ -178,19 +440,126 @@ func embedFile(path string, database *db.DB) error {
 	return nil
 }
 
-func splitTextIntoChunks(text string, chunkSize int) []string {
-	var chunks []string
-	runes := []rune(text) // handle UTF-8 safely
+// allStored reports whether every chunk of parent p is stored already;
+// parentOf maps chunks to their parent and exists tells which are stored
+func allStored(parentOf []int, exists []bool, p int) bool {
+	for i, q := range parentOf {
+		if q == p && !exists[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// previewChunks prints where a file would be split, for --dry-run, without
+// embedding or storing the chunks; the semantic chunker still embeds the
+// sentences to find its breakpoints
+func previewChunks(path, text string, split chunker.Chunker) error {
+	if semantic != nil {
+		breaks, threshold, err := semantic.Breakpoints(text)
+		if err != nil {
+			return fmt.Errorf("failed to embed the sentences of %s: %w", path, err)
+		}
+		fmt.Printf("%s: %d sentence boundaries, topic shift below similarity %.3f\n", path, len(breaks), threshold)
+		for _, b := range breaks {
+			switch {
+			case b.Split && b.Shift:
+				fmt.Printf("  split at %d (similarity %.3f): %s\n", b.Offset, b.Similarity, firstLine(text[b.Offset:]))
+			case b.Split:
+				fmt.Printf("  split at %d (size limit, similarity %.3f): %s\n", b.Offset, b.Similarity, firstLine(text[b.Offset:]))
+			case b.Shift:
+				fmt.Printf("  kept  at %d (similarity %.3f, chunk under --chunk-min): %s\n", b.Offset, b.Similarity, firstLine(text[b.Offset:]))
+			}
+		}
+		return nil
+	}
 
-	for start := 0; start < len(runes); start += chunkSize {
-		end := start + chunkSize
-		if end > len(runes) {
-			end = len(runes)
+	if split != nil && parentSplit != nil {
+		families, err := chunker.Nest(text, parentSplit, split)
+		if err != nil {
+			return fmt.Errorf("failed to chunk %s: %w", path, err)
 		}
-		chunks = append(chunks, string(runes[start:end]))
+		fmt.Printf("%s: %d parents\n", path, len(families))
+		n := 0
+		for i, f := range families {
+			printChunk("  parent", i+1, f.Parent)
+			for _, c := range f.Children {
+				n++
+				printChunk("    chunk", n, c)
+			}
+		}
+		return nil
 	}
 
-	return chunks
+	chunks := []chunker.Chunk{{Text: text, End: len(text)}}
+	if split != nil {
+		var err error
+		chunks, err = split.Chunk(text)
+		if err != nil {
+			return fmt.Errorf("failed to chunk %s: %w", path, err)
+		}
+	}
+	fmt.Printf("%s: %d chunks\n", path, len(chunks))
+	for i, c := range chunks {
+		printChunk("  chunk", i+1, c)
+	}
+	return nil
+}
+
+// printChunk prints the offsets, length and start of a chunk for --dry-run
+func printChunk(label string, n int, c chunker.Chunk) {
+	fmt.Printf("%s %d [%d, %d) %d characters: %s\n", label, n, c.Start, c.End, utf8.RuneCountInString(c.Text), firstLine(c.Text))
+}
+
+// firstLine returns the first line of text, shortened to 60 characters,
+// quoted
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(text, "\n")
+	if runes := []rune(line); len(runes) > 60 {
+		line = string(runes[:60]) + "…"
+	}
+	return strconv.Quote(line)
+}
+
+// chapterLabel returns the chapter or appendix of a Book page, taken from
+// its file name: "Chapter 4" or "Appendix B". It is "" for other files.
+func chapterLabel(sourceFile string) string {
+	number := parser.ChapterNumber(sourceFile)
+	if number == "" {
+		return ""
+	}
+	if chapter, _, _ := strings.Cut(number, "."); chapter[0] >= '0' && chapter[0] <= '9' {
+		return "Chapter " + chapter
+	}
+	return "Appendix " + number
+}
+
+// hasBreadcrumbs reports whether the chunks of --chunker and --parents
+// carry breadcrumbs, which addChapterBreadcrumb adds the chapter to
+func hasBreadcrumbs() bool {
+	h := string(chunker.StrategyHierarchical)
+	return chunkStrategy == h || parentStrategy == h
+}
+
+// addChapterBreadcrumb puts the chapter label of a Book page at the start
+// of the breadcrumbs of hierarchical chunks:
+// "Chapter 4 › What Is Ownership? › Variable Scope".
+func addChapterBreadcrumb(chunks []chunker.Chunk, sourceFile string) {
+	label := chapterLabel(sourceFile)
+	if label == "" {
+		return
+	}
+	for i := range chunks {
+		// chunks nested in a hierarchical parent carry its breadcrumb
+		if chunks[i].Strategy != chunker.StrategyHierarchical && chunks[i].Breadcrumb == "" {
+			continue
+		}
+		if chunks[i].Breadcrumb == "" {
+			chunks[i].Breadcrumb = label
+		} else {
+			chunks[i].Breadcrumb = label + chunker.BreadcrumbSeparator + chunks[i].Breadcrumb
+		}
+	}
 }
 
 func init() {
@@ -198,4 +567,14 @@ func init() {
 	embedCmd.Flags().BoolVarP(&writeToIndex, "write", "w", false, "Write embeddings to index (SQLite)")
 	embedCmd.Flags().BoolVarP(&useChunking, "chunk", "c", false, "Enable chunking of files for embeddings")
 	embedCmd.Flags().IntVar(&chunkSize, "chunk-size", 1000, "Size of each chunk (in characters) when chunking is enabled")
+	embedCmd.Flags().StringVar(&chunkStrategy, "chunker", "fixed", "Chunking strategy: fixed, sentence, paragraph, section, code, hierarchical or semantic")
+	embedCmd.Flags().StringVar(&chunkOverlap, "chunk-overlap", "", "Characters (200) or sentences (2s) each chunk repeats from the previous one")
+	embedCmd.Flags().IntVar(&chunkTokens, "chunk-tokens", 0, "Size of each chunk in tokens, instead of --chunk-size")
+	embedCmd.Flags().StringVar(&tokenVocab, "vocab", "", "tiktoken BPE vocabulary file used to count tokens (default: estimate)")
+	embedCmd.Flags().IntVar(&chunkMinSize, "chunk-min", 0, "Smallest chunk the semantic chunker ends at a topic shift (default: a quarter of the chunk size)")
+	embedCmd.Flags().IntVar(&semanticWindow, "semantic-window", 2, "Sentences on each side of a boundary the semantic chunker compares")
+	embedCmd.Flags().Float64Var(&breakpointPercentile, "breakpoint-percentile", 10, "Percentile of sentence similarities below which the semantic chunker splits")
+	embedCmd.Flags().StringVar(&parentStrategy, "parents", "", "Chunking strategy for parent passages stored with the chunks cut from them, e.g. section")
+	embedCmd.Flags().IntVar(&parentSize, "parent-size", 4000, "Size of each parent passage, in the unit of the chunk size")
+	embedCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print where files would be split instead of embedding them")
 }
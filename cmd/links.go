@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"ruborag/internal/db"
+	"ruborag/internal/parser"
+
+	"github.com/spf13/cobra"
+)
+
+var linksDirection string
+var aliasesPath string
+
+var linksCmd = &cobra.Command{
+	Use:   "links <file>",
+	Short: "Show the pages a page links to and the pages linking to it",
+	Long: `The links command shows the cross-references of one page of the book,
+as recorded by "ruborag parse --index-links".
+
+The page may be named by its HTML file, its mdBook source or its parsed
+output: ch04-01-what-is-ownership.html, src/ch04-01-what-is-ownership.md
+and ch04-01-what-is-ownership-parsed.txt all name the same page.
+
+Outbound links list the section they appear in and the page and anchor
+they point to. Inbound links list the linking page and section. With
+--aliases pointing to the aliases.json written by parse, links to redirect
+stubs are followed to their canonical page, and links to the stubs of the
+given page count as inbound links.
+
+Examples:
+
+  # Show inbound and outbound links
+  ruborag links ch04-02-references-and-borrowing.html
+
+  # Only the pages that refer to a chapter, following redirects
+  ruborag links --direction in --aliases parsed/aliases.json ch15-05-interior-mutability.html
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		switch linksDirection {
+		case "in", "out", "both":
+		default:
+			log.Fatalf("unknown direction %q (want in, out or both)", linksDirection)
+		}
+
+		aliases := parser.Aliases{}
+		if aliasesPath != "" {
+			loaded, err := parser.LoadAliases(aliasesPath)
+			if err != nil {
+				log.Fatalf("failed to load aliases: %v", err)
+			}
+			aliases = loaded
+		}
+		page := aliases.Resolve(parser.PageName(args[0]))
+
+		database, err := db.Open(db.DefaultDBName)
+		if err != nil {
+			log.Fatalf("failed to open database: %v", err)
+		}
+		defer database.Close()
+
+		if linksDirection == "out" || linksDirection == "both" {
+			out, err := database.LinksFrom(page)
+			if err != nil {
+				log.Fatalf("failed to load links: %v", err)
+			}
+			fmt.Printf("Links from %s (%d):\n", page, len(out))
+			for _, l := range out {
+				fmt.Printf("  %s -> %s  %q\n",
+					linkEnd("", l.SourceAnchor),
+					linkEnd(aliases.Resolve(l.TargetFile), l.TargetAnchor),
+					l.Text,
+				)
+			}
+		}
+
+		if linksDirection == "in" || linksDirection == "both" {
+			in, err := inboundLinks(database, aliases, page)
+			if err != nil {
+				log.Fatalf("failed to load links: %v", err)
+			}
+			if linksDirection == "both" {
+				fmt.Println()
+			}
+			fmt.Printf("Links to %s (%d):\n", page, len(in))
+			for _, l := range in {
+				fmt.Printf("  %s -> %s  %q\n",
+					linkEnd(l.SourceFile, l.SourceAnchor),
+					linkEnd("", l.TargetAnchor),
+					l.Text,
+				)
+			}
+		}
+	},
+}
+
+// inboundLinks returns the links to page and to every redirect stub that
+// resolves to it.
+func inboundLinks(database *db.DB, aliases parser.Aliases, page string) ([]db.StoredLink, error) {
+	links, err := database.LinksTo(page)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range aliases.Names() {
+		if name == page || aliases.Resolve(name) != page {
+			continue
+		}
+		more, err := database.LinksTo(name)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, more...)
+	}
+	return links, nil
+}
+
+// seeAlso returns up to limit distinct pages linked from or to page,
+// outbound links first.
+func seeAlso(database *db.DB, page string, limit int) ([]string, error) {
+	out, err := database.LinksFrom(page)
+	if err != nil {
+		return nil, err
+	}
+	in, err := database.LinksTo(page)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{page: true}
+	var pages []string
+	for _, l := range out {
+		if len(pages) < limit && !seen[l.TargetFile] {
+			seen[l.TargetFile] = true
+			pages = append(pages, l.TargetFile)
+		}
+	}
+	for _, l := range in {
+		if len(pages) < limit && !seen[l.SourceFile] {
+			seen[l.SourceFile] = true
+			pages = append(pages, l.SourceFile)
+		}
+	}
+	return pages, nil
+}
+
+// linkEnd formats a page and anchor as "page#anchor", "page" or "#anchor"
+func linkEnd(page, anchor string) string {
+	if page == "" && anchor == "" {
+		return "(top of page)"
+	}
+	if anchor == "" {
+		return page
+	}
+	return page + "#" + anchor
+}
+
+func init() {
+	rootCmd.AddCommand(linksCmd)
+
+	linksCmd.Flags().StringVar(&linksDirection, "direction", "both", "Links to show: in, out or both")
+	linksCmd.Flags().StringVar(&aliasesPath, "aliases", "", "Path to the aliases.json written by parse")
+}
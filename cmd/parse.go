@@ -1,12 +1,21 @@
 package cmd
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"ruborag/internal/db"
 	"ruborag/internal/parser"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -14,15 +23,50 @@ import (
 var writeToFile bool
 var outDir string
 var unbufferedIO bool
+var outputFormat string
+var keepBoring bool
+var contentSelectors []string
+var excludeSelectors []string
+var parseJobs int
+var indexLinks bool
+var streamText bool
+var boilerplateFraction float64
+var parseProfile string
+var stdinName string
+var unicodeForm string
+var foldPunctuation bool
+var collapseSpaces bool
 
 var parseCmd = &cobra.Command{
-	Use:   "parse [--write --out-dir <dir>] <input_path>...",
+	Use:   "parse [--write --out-dir <dir>] <input_path|->...",
 	Short: "Parse one or more input files",
 	Long: `Parse one or more input HTML files and extract their readable text content.
 
 The parse command removes HTML markup and outputs clean, human-readable text.
 Input arguments may be individual HTML files or directories containing HTML files.
 
+An argument may also be an mdBook source tree: a directory containing
+book.toml (or the book.toml file itself). Its chapters are read from the
+Markdown sources listed in src/SUMMARY.md, in reading order, with
+{{#include}} directives resolved. Each chapter produces the same document
+as the rendered HTML page would, numbered by its place in SUMMARY.md.
+
+An .epub argument is opened as an EPUB book: META-INF/container.xml points
+to the OPF package document, and each XHTML item of its spine is parsed in
+reading order as a separate document, with the book title from the OPF
+metadata. Output files are named after the item, and the manifest tracks
+each item as "<book.epub>!<item path>".
+
+An argument of "-" reads one HTML page from stdin. It is named by
+--stdin-name (default stdin.html) for its output file, redirect alias and
+chapter number. Stdin is always parsed and is not tracked in the manifest.
+
+A .zip, .tar, .tar.gz or .tgz argument is read as an archive without
+unpacking it to disk: every .html member is parsed like a file in a
+directory, and the manifest tracks each one as "<archive>!<member path>".
+Outputs are named after the member path below the directory all members
+share, with subdirectories joined by dots.
+
 By default, parsed content is written to stdout, which makes the command
 compatible with standard Unix pipelines for exploratory use.
 
@@ -31,6 +75,110 @@ of stdout. In this mode, an output directory must be specified using --out-dir.
 Each input HTML file produces a corresponding "-parsed.txt" file in the output
 directory.
 
+By default the page is flattened into a single line of text. With
+--format markdown, the document structure is kept instead: headings become
+#-levels, paragraphs are separated by blank lines, lists keep their bullets
+or numbers, and inline code is wrapped in backticks. Markdown output files
+use the "-parsed.md" suffix.
+
+Tables are kept as tables: Markdown tables in --format markdown, and
+tab-separated rows on lines of their own in text output. The first row is
+the header when it sits in <thead> or holds only <th> cells; a cell spanning
+several columns keeps its text in the first column.
+
+Figures, notes and warnings are kept as typed blocks. An image with its
+caption (<figure>/<figcaption>, or mdBook's image paragraph followed by a
+"Figure 4-1: ..." caption) becomes a Markdown image with its alt text,
+followed by the caption; <section class="note"> and class="warning"
+callouts become "> [!NOTE]" and "> [!WARNING]" alerts. In jsonl output each
+section lists its blocks with their kind, figure label, alt text and
+caption. Text output keeps image alt text in the prose.
+
+With --format jsonl, each page is written as one line of JSON describing
+the document: its title, chapter number (derived from names like ch04-01)
+and a list of sections with heading text, heading anchor, Markdown body and
+code listings. Output files use the "-parsed.jsonl" suffix.
+
+Code listings are emitted as fenced blocks tagged with their language and,
+for mdBook listings, the file name; the listing caption follows the block.
+Lines mdBook hides behind the "show hidden lines" toggle are dropped unless
+--keep-boring is given.
+
+Pages are read according to --profile. The default, auto, reads pages
+generated by rustdoc (cargo doc, rustup doc) with the rustdoc profile and
+everything else with the mdbook profile. The rustdoc profile produces one
+document per API item: its path (std::rc::Rc) as the title, its kind,
+signature and stability version in the jsonl "item" field, a first section
+with the signature and doc prose, and a section per method, variant, field
+and associated item declared on the item, each with its signature and
+docs. Examples become Rust code listings. Trait implementations, source
+views and the settings, help and all-items pages are left out; pages with
+no item are counted as skipped. Outputs of pages found in subdirectories
+are named after their relative path, so std/rc/struct.Rc.html becomes
+"std.rc.struct.Rc-parsed.txt". The generic profile works like mdbook but
+removes no page chrome unless --exclude-selector is given.
+
+Only the article body is extracted. For mdBook pages that is the <main>
+element; the sidebar, menu bar, theme popup, search bar and chapter
+navigation are discarded. Other static-site generators can be handled by
+passing --content-selector (tried in order, first match wins) and
+--exclude-selector. Selectors are simple CSS compounds such as "main",
+"div.article-body", "#content" or "[role=main]".
+
+Redirect stubs (pages with <meta http-equiv="refresh"> or a bare
+rel="canonical" link) produce no text. Instead, each one is recorded as an
+alias from its file name to the canonical page. With --write the alias map
+is merged into aliases.json in the output directory; otherwise the aliases
+are listed on stderr.
+
+In write mode, a manifest (manifest.json) in the output directory records
+each source path with its content hash, the parser version and the hash of
+the output written for it. Sources whose content, parser version and output
+are unchanged are skipped, and outputs whose source file no longer exists
+are removed. The run ends with a count of added, changed, removed and
+unchanged files.
+
+With --index-links, the links between pages of the book are stored in the
+local SQLite index (ruborag.db): for each section, the page and anchor it
+links to and the link text. Links to other sites are ignored. The graph can
+be explored with "ruborag links" and is used by "ruborag search --see-also".
+Re-parsing a page replaces its links.
+
+With --stream, HTML pages are extracted with a streaming tokenizer that
+writes text straight to the output instead of building the document tree
+first, so memory stays flat on single-page book exports and large rustdoc
+pages. Without --write, the page whose turn it is on stdout is printed as
+it is extracted; a page that another --jobs worker extracts ahead of its
+turn is held in memory until the pages before it have been printed, so
+memory only stays flat for a single input or with --jobs 1. The output is
+the same as the default text format; --stream only applies to --format
+text and cannot be combined with --index-links or --boilerplate, which
+needs whole blocks of every page.
+
+With --boilerplate <fraction>, a first pass over all HTML inputs counts the
+text of every innermost block (paragraph, heading, list item, leaf <div>)
+and treats blocks found in more than that fraction of the pages as
+boilerplate: the book title, "Keyboard shortcuts" help, print-page notices,
+license footers. They are removed from the output of every page. Code
+blocks and tables are never removed, and a block has to occur on at least
+two pages. The removed blocks are listed on stderr and, with --write, saved
+to boilerplate.json in the output directory. mdBook Markdown sources are
+not affected. This works for any site, not only mdBook.
+
+Text can be normalized so that searches do not depend on typography:
+--unicode nfc or nfkc applies a Unicode normalization form,
+--fold-punctuation turns curly quotes, dashes and "…" into ASCII, and
+--collapse-spaces turns non-breaking spaces into plain ones and drops
+zero-width spaces and soft hyphens. Code listings are left verbatim. In
+write mode the normalization is recorded in manifest.json, and
+"ruborag search --manifest" normalizes queries the same way.
+
+Files are parsed concurrently by --jobs workers (default 1). Output on
+stdout keeps the input order regardless of the number of jobs. Errors for
+individual files do not stop the run; they are collected and printed in a
+summary on stderr together with the throughput, and the command exits
+non-zero if any file failed.
+
 Examples:
   # Parse a single file and print to stdout
   ruborag parse book.html
@@ -41,6 +189,45 @@ Examples:
   # Parse a directory of HTML files and write output files
   ruborag parse rust-book/ --write --out-dir out-again
 
+  # Keep headings, lists and code as Markdown
+  ruborag parse --format markdown rust-book/ -w --out-dir parsed
+
+  # Parse an mdBook checkout from its Markdown sources
+  ruborag parse --format markdown -w --out-dir parsed ~/src/rust-book/
+
+  # Parse a page piped from another command
+  cat ch04-01-what-is-ownership.html | ruborag parse --stdin-name ch04-01-what-is-ownership.html -
+
+  # Parse the packaged book artifact without unpacking it
+  ruborag parse -w --out-dir parsed book.tar.gz
+
+  # Parse every chapter of an EPUB in reading order
+  ruborag parse --format jsonl rust-book.epub > book.jsonl
+
+  # Write one JSON document per page for the whole book
+  ruborag parse --format jsonl rust-book/ > book.jsonl
+
+  # Index the standard library docs next to the Book
+  ruborag parse -w --out-dir parsed ~/.rustup/toolchains/stable-x86_64-unknown-linux-gnu/share/doc/rust/html/std
+
+  # Extract the body of a non-mdBook site
+  ruborag parse --content-selector "div.post" --exclude-selector ".comments" site/
+
+  # Record the cross-reference graph in the index
+  ruborag parse --index-links -w --out-dir parsed rust-book/
+
+  # Extract a very large single-page export with constant memory
+  ruborag parse --stream print.html > book.txt
+
+  # Drop text repeated on more than half of the pages of a generic site
+  ruborag parse --boilerplate 0.5 --content-selector body -w --out-dir parsed site/
+
+  # Fold typography so "Rust’s" is indexed as "Rust's"
+  ruborag parse --unicode nfkc --fold-punctuation --collapse-spaces -w --out-dir parsed rust-book/
+
+  # Parse the whole book with 8 workers
+  ruborag parse --jobs 8 -w --out-dir parsed rust-book/
+
   # Use stdout output with Unix tools
   ruborag parse book.html | less
 `,
@@ -50,74 +237,987 @@ Examples:
 			cmd.Usage()
 			return
 		}
-		// check if given args is a html file, parse it
-		// if given file is a directory, parse every html file in the directory
-		for _, path := range args {
-			info, err := os.Stat(path)
+
+		format, err := parser.ParseFormat(outputFormat)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		profile, err := parser.ParseProfile(parseProfile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		form, err := parser.ParseUnicodeForm(unicodeForm)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		opts := parser.Options{
+			Format:           format,
+			Profile:          profile,
+			Unbuffered:       unbufferedIO,
+			KeepBoring:       keepBoring,
+			ContentSelectors: contentSelectors,
+			ExcludeSelectors: excludeSelectors,
+			Normalization: parser.Normalization{
+				Form:            form,
+				FoldPunctuation: foldPunctuation,
+				CollapseSpaces:  collapseSpaces,
+			},
+		}
+
+		var manifest *parser.Manifest
+		if writeToFile {
+			manifest, err = parser.LoadManifest(filepath.Join(outDir, parser.ManifestFileName))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error loading manifest: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		if streamText && (format != parser.FormatText || indexLinks || boilerplateFraction > 0) {
+			fmt.Fprintln(os.Stderr, "--stream only supports --format text without --index-links or --boilerplate")
+			os.Exit(1)
+		}
+		if boilerplateFraction < 0 || boilerplateFraction >= 1 {
+			fmt.Fprintln(os.Stderr, "--boilerplate must be a fraction between 0 and 1")
+			os.Exit(1)
+		}
+
+		var linkDB *db.DB
+		if indexLinks {
+			linkDB, err = db.Open(db.DefaultDBName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error opening index: %v\n", err)
+				os.Exit(1)
+			}
+			defer linkDB.Close()
+		}
+
+		inputs, inputErrs := collectParseInputs(args)
+		if boilerplateFraction > 0 {
+			opts.Boilerplate = detectBoilerplate(inputs, opts, parseJobs, boilerplateFraction)
+			if err := reportBoilerplate(opts.Boilerplate); err != nil {
+				inputErrs = append(inputErrs, fileError{path: parser.BoilerplateFileName, err: err})
+			}
+		}
+		summary := runParseJobs(inputs, opts, parseJobs, manifest, linkDB, os.Stdout)
+		summary.errors = append(inputErrs, summary.errors...)
+
+		var removedAliases []string
+		if manifest != nil {
+			removedAliases = summary.removeVanished(manifest, inputs)
+			manifest.Normalization = opts.Normalization
+			if err := manifest.Save(filepath.Join(outDir, parser.ManifestFileName)); err != nil {
+				summary.errors = append(summary.errors, fileError{path: parser.ManifestFileName, err: err})
+			}
+		}
+
+		if err := recordAliases(summary.aliases, removedAliases); err != nil {
+			summary.errors = append(summary.errors, fileError{path: parser.AliasFileName, err: err})
+		}
+
+		summary.print(os.Stderr)
+		if len(summary.errors) > 0 {
+			if linkDB != nil {
+				linkDB.Close()
+			}
+			os.Exit(1)
+		}
+	},
+}
+
+// fileError is a failure tied to one input path, reported in the summary
+type fileError struct {
+	path string
+	err  error
+}
+
+// manifest status of an input in write mode
+const (
+	statusAdded     = "added"
+	statusChanged   = "changed"
+	statusUnchanged = "unchanged"
+)
+
+// parseInput is one unit of work for the parse pool: an HTML file, one
+// chapter of an mdBook source tree or one spine item of an EPUB.
+type parseInput struct {
+	// path is the file the input is read from
+	path    string
+	book    *parser.Book
+	chapter int
+
+	// rel is the path relative to the directory argument or archive the
+	// page was found in, used to name the outputs of pages in subdirectories
+	rel string
+
+	// member is the document's path inside the EPUB or archive at path,
+	// or the page name given to stdin
+	member string
+	epub   *parser.EPUB
+	item   int
+
+	// data holds the content of inputs read into memory: EPUB items,
+	// archive members and stdin
+	data []byte
+}
+
+// stdinPath is the argument that reads a page from stdin
+const stdinPath = "-"
+
+// streamable reports whether the input is an HTML page, which --stream
+// can extract without building a Document
+func (in parseInput) streamable() bool {
+	return in.epub == nil && in.book == nil
+}
+
+// key identifies the input in the manifest
+func (in parseInput) key() string {
+	if in.path == stdinPath {
+		return stdinPath
+	}
+	return parser.ManifestKey(filepath.Clean(in.path), in.member)
+}
+
+// name is the file name used for the output file and redirect aliases
+func (in parseInput) name() string {
+	if in.member != "" {
+		return filepath.Base(in.member)
+	}
+	return filepath.Base(in.path)
+}
+
+// outputName is the file name the output is named after: the name, or
+// for pages in subdirectories of a directory argument the relative path
+// joined with dots, since rustdoc has an index.html in every module
+func (in parseInput) outputName() string {
+	if in.rel != "" && filepath.Dir(in.rel) != "." {
+		return strings.ReplaceAll(filepath.ToSlash(in.rel), "/", ".")
+	}
+	return in.name()
+}
+
+// size returns the number of input bytes, for the throughput summary
+func (in parseInput) size() int64 {
+	if in.data != nil {
+		return int64(len(in.data))
+	}
+	if info, err := os.Stat(in.path); err == nil {
+		return info.Size()
+	}
+	return 0
+}
+
+func (in parseInput) hash() (string, error) {
+	if in.data != nil {
+		return parser.HashBytes(in.data), nil
+	}
+	return parser.HashFile(in.path)
+}
+
+func (in parseInput) document(opts parser.Options) (*parser.Document, error) {
+	switch {
+	case in.epub != nil:
+		return in.epub.ParseItem(in.item, opts)
+	case in.book != nil:
+		return in.book.ParseChapter(in.chapter, opts)
+	case in.data != nil:
+		return parser.ParseDocument(bytes.NewReader(in.data), in.name(), opts)
+	}
+	return parser.ParseDocumentFile(in.path, opts)
+}
+
+// extractText streams the text of an HTML page to w
+func (in parseInput) extractText(w io.Writer, opts parser.Options) error {
+	if in.data != nil {
+		return parser.ExtractText(w, bytes.NewReader(in.data), opts)
+	}
+	return parser.ExtractTextFile(w, in.path, opts)
+}
+
+// parse renders the input and returns its Document when one was built.
+// HTML pages in text format are extracted directly, and only parsed into a
+// Document as well when links are indexed.
+func (in parseInput) parse(opts parser.Options) (string, *parser.Document, error) {
+	if in.epub == nil && in.book == nil && opts.Format == parser.FormatText {
+		var content string
+		var err error
+		if in.data != nil {
+			content, err = parser.Parse(bytes.NewReader(in.data), opts)
+		} else {
+			content, err = parser.ParseFile(in.path, opts)
+		}
+		if err != nil || !indexLinks {
+			return content, nil, err
+		}
+		doc, err := in.document(opts)
+		return content, doc, err
+	}
+
+	doc, err := in.document(opts)
+	if err != nil {
+		return "", nil, err
+	}
+	content, err := doc.Render(opts.Format)
+	return content, doc, err
+}
+
+// documentLinks flattens the links of doc for the index, keyed by page name.
+func documentLinks(doc *parser.Document) []db.StoredLink {
+	var links []db.StoredLink
+	for _, s := range doc.Sections {
+		for _, l := range s.Links {
+			links = append(links, db.StoredLink{
+				SourceFile:   parser.PageName(doc.Source),
+				SourceAnchor: s.Anchor,
+				TargetFile:   parser.PageName(l.Target),
+				TargetAnchor: l.Anchor,
+				Text:         l.Text,
+			})
+		}
+	}
+	return links
+}
+
+// blocks returns the text blocks of an HTML input for boilerplate
+// detection, and false for inputs that are not HTML pages.
+func (in parseInput) blocks(opts parser.Options) ([]string, bool, error) {
+	switch {
+	case in.book != nil:
+		return nil, false, nil
+	case in.data != nil:
+		blocks, err := parser.BoilerplateBlocks(bytes.NewReader(in.data), opts)
+		return blocks, true, err
+	}
+	blocks, err := parser.BoilerplateBlocksFile(in.path, opts)
+	return blocks, true, err
+}
+
+// detectBoilerplate counts the blocks of every HTML input with jobs workers
+// and returns those found in more than fraction of the pages. Redirect
+// stubs and pages that fail to parse are not counted; their errors are
+// reported by the parse run itself.
+func detectBoilerplate(inputs []parseInput, opts parser.Options, jobs int, fraction float64) *parser.Boilerplate {
+	var counter parser.BoilerplateCounter
+	var mu sync.Mutex
+
+	work := make(chan parseInput)
+	var wg sync.WaitGroup
+	for range max(1, min(jobs, len(inputs))) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for in := range work {
+				blocks, ok, err := in.blocks(opts)
+				if !ok || err != nil {
+					continue
+				}
+				mu.Lock()
+				counter.Add(blocks)
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, in := range inputs {
+		work <- in
+	}
+	close(work)
+	wg.Wait()
+
+	return counter.Boilerplate(fraction)
+}
+
+// reportBoilerplate lists the removed blocks on stderr and, in write mode,
+// saves them to boilerplate.json.
+func reportBoilerplate(b *parser.Boilerplate) error {
+	fmt.Fprintf(os.Stderr, "boilerplate: %d blocks found on more than %.0f%% of %d pages\n",
+		len(b.Blocks), b.Fraction*100, b.Documents)
+	for _, block := range b.Blocks {
+		text := block.Text
+		if r := []rune(text); len(r) > 72 {
+			text = string(r[:72]) + "…"
+		}
+		fmt.Fprintf(os.Stderr, "  %d/%d  %q\n", block.Documents, b.Documents, text)
+	}
+
+	if !writeToFile {
+		return nil
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return err
+	}
+	return b.Save(filepath.Join(outDir, parser.BoilerplateFileName))
+}
+
+// parseResult is what a worker reports back for one input file
+type parseResult struct {
+	index    int
+	path     string
+	name     string
+	content  string
+	redirect string
+	bytes    int64
+	err      error
+
+	// set in write mode only
+	status string
+	entry  *parser.ManifestEntry
+
+	// set when links are indexed
+	page  string
+	links []db.StoredLink
+
+	// skipped is set for pages with nothing to extract
+	skipped bool
+	// written is set when the content went to stdout already, with --stream
+	written bool
+}
+
+// parseSummary aggregates the results of a parse run
+type parseSummary struct {
+	files     int
+	parsed    int
+	redirects int
+	skipped   int
+	bytes     int64
+	elapsed   time.Duration
+	aliases   parser.Aliases
+	errors    []fileError
+
+	// jobs is the number of workers the run actually used
+	jobs int
+	// out receives the parsed content when not writing files
+	out io.Writer
+
+	// linkDB receives the link graph with --index-links
+	linkDB *db.DB
+	opts   parser.Options
+
+	// manifest bookkeeping in write mode
+	manifest  *parser.Manifest
+	added     int
+	changed   int
+	removed   int
+	unchanged int
+}
+
+// collectParseInputs expands the command arguments into the list of inputs
+// to parse, in argument order with directories walked lexically and mdBook
+// chapters in SUMMARY.md order.
+func collectParseInputs(args []string) ([]parseInput, []fileError) {
+	var inputs []parseInput
+	var errs []fileError
+
+	// check if given args is a html file, parse it
+	// if given file is a directory, parse every html file in the directory
+	// unless it is an mdBook source tree, then parse its chapters
+	// an epub is parsed item by item in spine order
+	// an archive is parsed like a directory, member by member
+	// "-" reads one page from stdin
+	readStdin := false
+	for _, path := range args {
+		if path == stdinPath {
+			if readStdin {
+				continue
+			}
+			readStdin = true
+			data, err := io.ReadAll(os.Stdin)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "error accessing %s: %v\n", path, err)
+				errs = append(errs, fileError{path: path, err: err})
 				continue
 			}
+			inputs = append(inputs, parseInput{path: stdinPath, member: stdinName, data: data})
+			continue
+		}
 
-			if info.IsDir() {
-				err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
-					if err != nil {
-						return err
-					}
+		info, err := os.Stat(path)
+		if err != nil {
+			errs = append(errs, fileError{path: path, err: err})
+			continue
+		}
 
-					if d.IsDir() {
-						return nil
-					}
+		bookDir := ""
+		if info.IsDir() && parser.IsBook(path) {
+			bookDir = path
+		} else if filepath.Base(path) == parser.BookConfigName {
+			bookDir = filepath.Dir(path)
+		}
+		if bookDir != "" {
+			book, err := parser.LoadBook(bookDir)
+			if err != nil {
+				errs = append(errs, fileError{path: path, err: err})
+				continue
+			}
+			for i := range book.Chapters {
+				inputs = append(inputs, parseInput{path: book.ChapterPath(i), book: book, chapter: i})
+			}
+			continue
+		}
 
-					if filepath.Ext(p) != ".html" {
-						return nil
-					}
+		if !info.IsDir() && filepath.Ext(path) == ".epub" {
+			book, err := parser.ReadEPUB(path)
+			if err != nil {
+				errs = append(errs, fileError{path: path, err: err})
+				continue
+			}
+			for i, item := range book.Items {
+				inputs = append(inputs, parseInput{path: path, member: item.Href, epub: book, item: i, data: item.Data})
+			}
+			continue
+		}
 
-					return parseSingleFile(p)
-				})
+		if !info.IsDir() && parser.IsArchive(path) {
+			members, err := parser.ReadArchive(path, func(name string) bool {
+				return filepath.Ext(name) == ".html"
+			})
+			if err != nil {
+				errs = append(errs, fileError{path: path, err: err})
+				continue
+			}
+			names := make([]string, len(members))
+			for i, m := range members {
+				names[i] = m.Name
+			}
+			root := commonDir(names)
+			for _, m := range members {
+				rel := strings.TrimPrefix(m.Name, root)
+				inputs = append(inputs, parseInput{path: path, member: m.Name, rel: rel, data: m.Data})
+			}
+			continue
+		}
 
+		if info.IsDir() {
+			err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "error parsing directory %s: %v\n", path, err)
+					errs = append(errs, fileError{path: p, err: err})
+					return nil
 				}
-			} else {
-				if filepath.Ext(path) != ".html" {
-					fmt.Fprintf(os.Stderr, "skipping non-html file: %s\n", path)
-					continue
+				if !d.IsDir() && filepath.Ext(p) == ".html" {
+					rel, _ := filepath.Rel(path, p)
+					inputs = append(inputs, parseInput{path: p, rel: rel})
 				}
-				if err := parseSingleFile(path); err != nil {
-					fmt.Fprintf(os.Stderr, "error parsing file %s: %v\n", path, err)
+				return nil
+			})
+			if err != nil {
+				errs = append(errs, fileError{path: path, err: err})
+			}
+			continue
+		}
+
+		if filepath.Ext(path) != ".html" {
+			fmt.Fprintf(os.Stderr, "skipping unsupported file: %s\n", path)
+			continue
+		}
+		inputs = append(inputs, parseInput{path: path})
+	}
+
+	return inputs, errs
+}
+
+// commonDir returns the directory prefix, with a trailing slash, shared by
+// all slash-separated names, or "" when they have none in common.
+func commonDir(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	prefix := path.Dir(names[0]) + "/"
+	for _, name := range names[1:] {
+		for prefix != "./" && !strings.HasPrefix(name, prefix) {
+			prefix = path.Dir(strings.TrimSuffix(prefix, "/")) + "/"
+		}
+	}
+	if prefix == "./" {
+		return ""
+	}
+	return prefix
+}
+
+// runParseJobs parses inputs with a pool of jobs workers, at least one and
+// at most one per input. Results are emitted to out in input order, so the
+// output does not depend on scheduling.
+func runParseJobs(inputs []parseInput, opts parser.Options, jobs int, manifest *parser.Manifest, linkDB *db.DB, out io.Writer) parseSummary {
+	jobs = max(1, min(jobs, len(inputs)))
+	summary := parseSummary{aliases: parser.Aliases{}, manifest: manifest, linkDB: linkDB, opts: opts, jobs: jobs, out: out}
+	start := time.Now()
+
+	// previous entries are looked up before the workers start, since add
+	// writes the new ones into the manifest while they run
+	prevs := make([]*parser.ManifestEntry, len(inputs))
+	if manifest != nil {
+		for i, in := range inputs {
+			if e, ok := manifest.Entries[in.key()]; ok {
+				prevs[i] = &e
+			}
+		}
+	}
+
+	// with --stream on stdout, workers write their pages themselves, in
+	// input order
+	var ordered *orderedOutput
+	if streamText && !writeToFile {
+		ordered = newOrderedOutput(out)
+	}
+
+	work := make(chan int)
+	results := make(chan parseResult)
+
+	var wg sync.WaitGroup
+	for range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				if ordered == nil {
+					results <- parseSingleFile(i, inputs[i], opts, prevs[i])
+					continue
 				}
+				res := streamToOutput(i, inputs[i], opts, ordered.writer(i))
+				ordered.done(i)
+				results <- res
 			}
+		}()
+	}
+
+	go func() {
+		for i := range inputs {
+			work <- i
 		}
-	},
+		close(work)
+		wg.Wait()
+		close(results)
+	}()
+
+	// hold finished results until every earlier input has been emitted
+	pending := make(map[int]parseResult)
+	next := 0
+	for res := range results {
+		pending[res.index] = res
+		for {
+			r, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			summary.add(r)
+		}
+	}
+
+	summary.elapsed = time.Since(start)
+	return summary
+}
+
+func (s *parseSummary) add(r parseResult) {
+	s.files++
+	s.bytes += r.bytes
+
+	// stdin is written but not tracked, having no source to compare on the
+	// next run
+	if r.err == nil && r.entry != nil && r.path != stdinPath {
+		s.manifest.Entries[r.entry.Key()] = *r.entry
+	}
+	switch {
+	case r.err != nil:
+	case r.status == statusAdded:
+		s.added++
+	case r.status == statusChanged:
+		s.changed++
+	case r.status == statusUnchanged:
+		s.unchanged++
+		return
+	}
+
+	switch {
+	case r.err != nil:
+		s.errors = append(s.errors, fileError{path: r.path, err: r.err})
+	case r.redirect != "":
+		s.redirects++
+		s.aliases[r.name] = r.redirect
+	case r.skipped:
+		s.skipped++
+	default:
+		s.parsed++
+		if !writeToFile && !r.written {
+			fmt.Fprintln(s.out, r.content)
+		}
+	}
+
+	if r.err == nil && r.page != "" && s.linkDB != nil {
+		if err := s.linkDB.ReplaceLinks(r.page, r.links); err != nil {
+			s.errors = append(s.errors, fileError{path: r.path, err: err})
+		}
+	}
+}
+
+// print writes the run summary, throughput and any per-file errors.
+func (s *parseSummary) print(w io.Writer) {
+	secs := s.elapsed.Seconds()
+	fmt.Fprintf(w,
+		"parsed %d files (%d redirects, %d skipped, %d errors) in %s with %d jobs: %.1f files/s, %.2f MB/s\n",
+		s.parsed, s.redirects, s.skipped, len(s.errors), s.elapsed.Round(time.Millisecond), s.jobs,
+		float64(s.files)/secs, float64(s.bytes)/(1<<20)/secs,
+	)
+	if s.manifest != nil {
+		fmt.Fprintf(w, "%d added, %d changed, %d removed, %d unchanged\n",
+			s.added, s.changed, s.removed, s.unchanged)
+	}
+	for _, e := range s.errors {
+		fmt.Fprintf(w, "  error: %s: %v\n", e.path, e.err)
+	}
+}
+
+// removeVanished drops manifest entries whose source file no longer exists,
+// or whose EPUB item is gone from an EPUB parsed in this run, deleting their
+// outputs, and returns the aliases of removed redirect stubs. Sources that
+// still exist but were not part of this run are kept.
+func (s *parseSummary) removeVanished(manifest *parser.Manifest, inputs []parseInput) []string {
+	seen := make(map[string]bool, len(inputs))
+	containers := make(map[string]bool)
+	for _, in := range inputs {
+		seen[in.key()] = true
+		if in.member != "" {
+			containers[filepath.Clean(in.path)] = true
+		}
+	}
+
+	var removedAliases []string
+	for key, entry := range manifest.Entries {
+		if seen[key] {
+			continue
+		}
+		if !containers[entry.Source] {
+			if _, err := os.Stat(entry.Source); !errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+		}
+
+		if entry.Output != "" {
+			err := os.Remove(filepath.Join(outDir, entry.Output))
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				s.errors = append(s.errors, fileError{path: entry.Output, err: err})
+				continue
+			}
+		}
+		if entry.Redirect != "" {
+			name := entry.Source
+			if entry.Member != "" {
+				name = entry.Member
+			}
+			removedAliases = append(removedAliases, filepath.Base(name))
+		}
+		delete(manifest.Entries, key)
+		s.removed++
+	}
+	return removedAliases
 }
 
-func parseSingleFile(inputPath string) error {
-	var content string
-	var err error
-	if unbufferedIO {
-		content, err = parser.RemoveHTMLTagsFromFileUnbuffered(inputPath)
-	} else {
-		content, err = parser.RemoveHTMLTagsFromFile(inputPath)
+// parseSingleFile parses one input and, in write mode, writes its output
+// file. Printing to stdout is left to the caller to keep ordering stable.
+// prev is the input's manifest entry from an earlier run, if any.
+func parseSingleFile(index int, in parseInput, opts parser.Options, prev *parser.ManifestEntry) parseResult {
+	res := parseResult{index: index, path: in.key(), name: in.name(), bytes: in.size()}
+
+	var entry *parser.ManifestEntry
+	if writeToFile {
+		hash, err := in.hash()
+		if err != nil {
+			res.err = err
+			return res
+		}
+		entry = &parser.ManifestEntry{
+			Source:        filepath.Clean(in.path),
+			Member:        in.member,
+			SourceHash:    hash,
+			ParserVersion: parser.VersionFor(opts),
+			Links:         indexLinks,
+		}
+		if isUnchanged(prev, entry) {
+			res.status, res.entry, res.redirect = statusUnchanged, prev, prev.Redirect
+			return res
+		}
+		res.status = statusAdded
+		if prev != nil {
+			res.status = statusChanged
+		}
+	}
+
+	if streamText && writeToFile && in.streamable() {
+		return streamSingleFile(res, in, opts, entry, prev)
+	}
+
+	content, doc, err := in.parse(opts)
+	if doc != nil && indexLinks {
+		res.page, res.links = parser.PageName(doc.Source), documentLinks(doc)
+	}
+	if target, ok := parser.IsRedirect(err); ok {
+		res.redirect = target
+		if entry != nil {
+			entry.Redirect = target
+			res.entry = entry
+			res.err = removeStaleOutput(prev, "")
+		}
+		return res
+	}
+	if errors.Is(err, parser.ErrNoItem) {
+		return skipSingleFile(res, entry, prev)
 	}
 	if err != nil {
-		return err
+		res.err = err
+		return res
 	}
 
 	if writeToFile {
 		if err := os.MkdirAll(outDir, 0755); err != nil {
-			return err
+			res.err = err
+			return res
 		}
 
-		base := filepath.Base(inputPath)
+		base := in.outputName()
 		name := strings.TrimSuffix(base, filepath.Ext(base))
-		outPath := filepath.Join(outDir, name+"-parsed.txt")
+		entry.Output = name + parsedSuffix(opts.Format)
+		entry.OutputHash = parser.HashBytes([]byte(content))
+		res.entry = entry
+
+		if err := os.WriteFile(filepath.Join(outDir, entry.Output), []byte(content), 0644); err != nil {
+			res.err = err
+			return res
+		}
+		res.err = removeStaleOutput(prev, entry.Output)
+		return res
+	}
+
+	res.content = content
+	return res
+}
+
+// streamToOutput parses one input for --stream without --write, writing
+// its text to w: HTML pages are extracted with the streaming tokenizer as
+// they are read, other inputs are written once parsed.
+func streamToOutput(index int, in parseInput, opts parser.Options, w io.Writer) parseResult {
+	if !in.streamable() {
+		res := parseSingleFile(index, in, opts, nil)
+		if res.err == nil && res.redirect == "" && !res.skipped {
+			_, res.err = fmt.Fprintln(w, res.content)
+			res.content, res.written = "", true
+		}
+		return res
+	}
+
+	res := parseResult{index: index, path: in.key(), name: in.name(), bytes: in.size(), written: true}
+	// redirect stubs and pages without an item are found before any text
+	// is written
+	err := in.extractText(w, opts)
+	switch target, ok := parser.IsRedirect(err); {
+	case ok:
+		res.redirect = target
+	case errors.Is(err, parser.ErrNoItem):
+		res.skipped = true
+	case err != nil:
+		res.err = err
+	default:
+		_, res.err = io.WriteString(w, "\n")
+	}
+	return res
+}
+
+// orderedOutput lets workers write pages to out in input order. The page
+// whose turn it is goes straight through; pages written ahead of it are
+// held in memory until every earlier page is done.
+type orderedOutput struct {
+	mu   sync.Mutex
+	out  io.Writer
+	next int
+	held map[int]*bytes.Buffer
+	// finished holds pages done before their turn
+	finished map[int]bool
+}
+
+func newOrderedOutput(out io.Writer) *orderedOutput {
+	return &orderedOutput{out: out, held: make(map[int]*bytes.Buffer), finished: make(map[int]bool)}
+}
+
+// writer returns the writer for the page of input index.
+func (o *orderedOutput) writer(index int) io.Writer {
+	return orderedWriter{o, index}
+}
+
+// done marks the page of input index as complete, writing out the pages
+// after it that were held for their turn.
+func (o *orderedOutput) done(index int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.finished[index] = true
+	for o.finished[o.next] {
+		delete(o.finished, o.next)
+		o.next++
+		// the next page, finished or not, is written from here on
+		if buf, ok := o.held[o.next]; ok {
+			delete(o.held, o.next)
+			o.out.Write(buf.Bytes())
+		}
+	}
+}
+
+type orderedWriter struct {
+	o     *orderedOutput
+	index int
+}
+
+func (w orderedWriter) Write(p []byte) (int, error) {
+	w.o.mu.Lock()
+	defer w.o.mu.Unlock()
+	if w.index == w.o.next {
+		return w.o.out.Write(p)
+	}
+	buf, ok := w.o.held[w.index]
+	if !ok {
+		buf = new(bytes.Buffer)
+		w.o.held[w.index] = buf
+	}
+	return buf.Write(p)
+}
+
+// streamSingleFile extracts an HTML file with the streaming tokenizer into
+// its output file, hashed on the way.
+func streamSingleFile(res parseResult, in parseInput, opts parser.Options, entry, prev *parser.ManifestEntry) parseResult {
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		res.err = err
+		return res
+	}
+	base := in.outputName()
+	entry.Output = strings.TrimSuffix(base, filepath.Ext(base)) + parsedSuffix(opts.Format)
+	output := filepath.Join(outDir, entry.Output)
 
-		return os.WriteFile(outPath, []byte(content), 0644)
+	// write to a temporary file so a failed or redirect page leaves the
+	// previous output in place until it is replaced or removed
+	file, err := os.CreateTemp(outDir, entry.Output+".*")
+	if err != nil {
+		res.err = err
+		return res
+	}
+	defer os.Remove(file.Name())
+
+	h := sha256.New()
+	err = in.extractText(io.MultiWriter(file, h), opts)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if target, ok := parser.IsRedirect(err); ok {
+		res.redirect = target
+		entry.Output, entry.Redirect = "", target
+		res.entry = entry
+		res.err = removeStaleOutput(prev, "")
+		return res
+	}
+	if errors.Is(err, parser.ErrNoItem) {
+		entry.Output = ""
+		return skipSingleFile(res, entry, prev)
+	}
+	if err != nil {
+		res.err = err
+		return res
+	}
+
+	if err := os.Chmod(file.Name(), 0644); err != nil {
+		res.err = err
+		return res
+	}
+	if err := os.Rename(file.Name(), output); err != nil {
+		res.err = err
+		return res
+	}
+	entry.OutputHash = hex.EncodeToString(h.Sum(nil))
+	res.entry = entry
+	res.err = removeStaleOutput(prev, entry.Output)
+	return res
+}
+
+// skipSingleFile records an input with nothing to extract, removing the
+// output of an earlier run.
+func skipSingleFile(res parseResult, entry, prev *parser.ManifestEntry) parseResult {
+	res.skipped = true
+	if entry != nil {
+		entry.Skipped = true
+		res.entry = entry
+		res.err = removeStaleOutput(prev, "")
+	}
+	return res
+}
+
+// isUnchanged reports whether the source and parser version match the
+// previous run, the output written then is still intact and, with
+// --index-links, the source's links were indexed then too.
+func isUnchanged(prev, current *parser.ManifestEntry) bool {
+	if prev == nil || prev.SourceHash != current.SourceHash || prev.ParserVersion != current.ParserVersion {
+		return false
+	}
+	// links were not indexed when the output was written
+	if current.Links && !prev.Links {
+		return false
+	}
+	if prev.Output == "" {
+		return prev.Redirect != "" || prev.Skipped
+	}
+	hash, err := parser.HashFile(filepath.Join(outDir, prev.Output))
+	return err == nil && hash == prev.OutputHash
+}
+
+// removeStaleOutput deletes the previous output of a source when the new
+// run writes a different file, e.g. after switching --format.
+func removeStaleOutput(prev *parser.ManifestEntry, output string) error {
+	if prev == nil || prev.Output == "" || prev.Output == output {
+		return nil
+	}
+	err := os.Remove(filepath.Join(outDir, prev.Output))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// recordAliases merges the redirect stubs found in this run into the alias
+// map in the output directory, or lists them on stderr when not writing.
+// removed names redirect stubs whose source has disappeared.
+func recordAliases(aliases parser.Aliases, removed []string) error {
+	if len(aliases) == 0 && len(removed) == 0 {
+		return nil
 	}
 
-	fmt.Println(content)
-	return nil
+	if !writeToFile {
+		for _, name := range aliases.Names() {
+			fmt.Fprintf(os.Stderr, "redirect %s -> %s\n", name, aliases[name])
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return err
+	}
+	aliasPath := filepath.Join(outDir, parser.AliasFileName)
+	existing, err := parser.LoadAliases(aliasPath)
+	if err != nil {
+		return err
+	}
+	for name, target := range aliases {
+		existing[name] = target
+	}
+	for _, name := range removed {
+		delete(existing, name)
+	}
+	return existing.Save(aliasPath)
+}
+
+// parsedSuffix returns the output file suffix for a format
+func parsedSuffix(format parser.Format) string {
+	switch format {
+	case parser.FormatMarkdown:
+		return "-parsed.md"
+	case parser.FormatJSONL:
+		return "-parsed.jsonl"
+	}
+	return "-parsed.txt"
 }
 
 func init() {
@@ -125,4 +1225,17 @@ func init() {
 	parseCmd.Flags().BoolVarP(&writeToFile, "write", "w", false, "Write output to a file")
 	parseCmd.Flags().BoolVarP(&unbufferedIO, "unbuffered-io", "u", false, "Read and write files without using buffered IO")
 	parseCmd.Flags().StringVar(&outDir, "out-dir", "", "Directory to write parsed output")
+	parseCmd.Flags().IntVarP(&parseJobs, "jobs", "j", 1, "Number of files to parse concurrently")
+	parseCmd.Flags().BoolVar(&indexLinks, "index-links", false, "Store links between pages in the index")
+	parseCmd.Flags().BoolVar(&streamText, "stream", false, "Extract text with a streaming tokenizer instead of building the DOM")
+	parseCmd.Flags().Float64Var(&boilerplateFraction, "boilerplate", 0, "Remove text blocks found on more than this fraction of pages (0 disables)")
+	parseCmd.Flags().StringVar(&parseProfile, "profile", "auto", "Page conventions: auto, mdbook, rustdoc or generic")
+	parseCmd.Flags().StringVar(&stdinName, "stdin-name", "stdin.html", "Page name for HTML read from stdin with \"-\"")
+	parseCmd.Flags().StringVar(&unicodeForm, "unicode", "none", "Unicode normalization form: none, nfc or nfkc")
+	parseCmd.Flags().BoolVar(&foldPunctuation, "fold-punctuation", false, "Replace curly quotes, dashes and ellipses with ASCII")
+	parseCmd.Flags().BoolVar(&collapseSpaces, "collapse-spaces", false, "Replace non-breaking spaces and drop zero-width spaces and soft hyphens")
+	parseCmd.Flags().StringVar(&outputFormat, "format", "text", "Output format: text, markdown or jsonl")
+	parseCmd.Flags().BoolVar(&keepBoring, "keep-boring", false, "Keep hidden (boring) lines in mdBook code listings")
+	parseCmd.Flags().StringSliceVar(&contentSelectors, "content-selector", nil, "Selector for the article body, tried in order (default mdBook <main>, then common layouts)")
+	parseCmd.Flags().StringSliceVar(&excludeSelectors, "exclude-selector", nil, "Selector for page chrome to discard (default mdBook sidebar, menus and navigation)")
 }
@@ -0,0 +1,226 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ruborag/internal/parser"
+)
+
+// writePages writes n small HTML pages and returns their inputs, in order.
+func writePages(t *testing.T, n int) []parseInput {
+	t.Helper()
+	dir := t.TempDir()
+	inputs := make([]parseInput, n)
+	for i := range inputs {
+		path := filepath.Join(dir, fmt.Sprintf("page%02d.html", i))
+		html := fmt.Sprintf("<html><body><main><p>Page %d.</p></main></body></html>", i)
+		if err := os.WriteFile(path, []byte(html), 0o644); err != nil {
+			t.Fatalf("failed to write page: %v", err)
+		}
+		inputs[i] = parseInput{path: path}
+	}
+	return inputs
+}
+
+func TestRunParseJobs(t *testing.T) {
+	inputs := writePages(t, 12)
+	missing := filepath.Join(t.TempDir(), "missing.html")
+	inputs[5] = parseInput{path: missing}
+
+	var out bytes.Buffer
+	summary := runParseJobs(inputs, parser.Options{}, 4, nil, nil, &out)
+
+	var want []string
+	for i := range inputs {
+		if i != 5 {
+			want = append(want, fmt.Sprintf("Page %d.", i))
+		}
+	}
+	if got := strings.Split(strings.TrimSpace(out.String()), "\n"); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("expected pages in input order, got %q", got)
+	}
+	if summary.files != 12 || summary.parsed != 11 {
+		t.Fatalf("expected 12 files and 11 parsed, got %d and %d", summary.files, summary.parsed)
+	}
+	if len(summary.errors) != 1 || summary.errors[0].path != missing {
+		t.Fatalf("expected one error for %s, got %v", missing, summary.errors)
+	}
+	if summary.jobs != 4 {
+		t.Fatalf("expected 4 jobs, got %d", summary.jobs)
+	}
+}
+
+// parseWrite runs "parse -w --jobs 4" over dir into out, loading and
+// saving the manifest like the command does.
+func parseWrite(t *testing.T, dir, out string) parseSummary {
+	t.Helper()
+	manifestPath := filepath.Join(out, parser.ManifestFileName)
+	manifest, err := parser.LoadManifest(manifestPath)
+	if err != nil {
+		t.Fatalf("failed to load manifest: %v", err)
+	}
+	inputs, errs := collectParseInputs([]string{dir})
+	if len(errs) != 0 {
+		t.Fatalf("failed to collect inputs: %v", errs)
+	}
+	summary := runParseJobs(inputs, parser.Options{}, 4, manifest, nil, io.Discard)
+	summary.removeVanished(manifest, inputs)
+	if len(summary.errors) != 0 {
+		t.Fatalf("failed to parse: %v", summary.errors)
+	}
+	if err := manifest.Save(manifestPath); err != nil {
+		t.Fatalf("failed to save manifest: %v", err)
+	}
+	return summary
+}
+
+func TestRunParseJobsManifest(t *testing.T) {
+	inputs := writePages(t, 8)
+	dir := filepath.Dir(inputs[0].path)
+	out := t.TempDir()
+	writeToFile, outDir = true, out
+	defer func() { writeToFile, outDir = false, "" }()
+
+	s := parseWrite(t, dir, out)
+	if s.added != 8 || s.changed != 0 || s.removed != 0 || s.unchanged != 0 {
+		t.Fatalf("expected 8 added, got %d added, %d changed, %d removed, %d unchanged", s.added, s.changed, s.removed, s.unchanged)
+	}
+	if _, err := os.Stat(filepath.Join(out, "page05-parsed.txt")); err != nil {
+		t.Fatalf("failed to write output: %v", err)
+	}
+
+	// one page edited, one deleted and one new
+	if err := os.WriteFile(inputs[2].path, []byte("<main><p>Edited.</p></main>"), 0o644); err != nil {
+		t.Fatalf("failed to edit page: %v", err)
+	}
+	if err := os.Remove(inputs[5].path); err != nil {
+		t.Fatalf("failed to remove page: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "new.html"), []byte("<main><p>New.</p></main>"), 0o644); err != nil {
+		t.Fatalf("failed to write page: %v", err)
+	}
+	s = parseWrite(t, dir, out)
+	if s.added != 1 || s.changed != 1 || s.removed != 1 || s.unchanged != 6 {
+		t.Fatalf("expected 1 added, 1 changed, 1 removed and 6 unchanged, got %d, %d, %d and %d", s.added, s.changed, s.removed, s.unchanged)
+	}
+	if _, err := os.Stat(filepath.Join(out, "page05-parsed.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected the output of the deleted page to be removed, got %v", err)
+	}
+}
+
+func TestRunParseJobsCount(t *testing.T) {
+	inputs := writePages(t, 3)
+	for _, tt := range []struct {
+		jobs, want int
+	}{
+		{-3, 1},
+		{0, 1},
+		{2, 2},
+		{8, 3},
+	} {
+		var out bytes.Buffer
+		summary := runParseJobs(inputs, parser.Options{}, tt.jobs, nil, nil, &out)
+		if summary.jobs != tt.want {
+			t.Errorf("--jobs %d: expected %d jobs, got %d", tt.jobs, tt.want, summary.jobs)
+		}
+
+		var report bytes.Buffer
+		summary.print(&report)
+		if want := fmt.Sprintf("with %d jobs", tt.want); !strings.Contains(report.String(), want) {
+			t.Errorf("--jobs %d: expected %q in %q", tt.jobs, want, report.String())
+		}
+	}
+}
+
+func TestRunParseJobsStream(t *testing.T) {
+	inputs := writePages(t, 6)
+	var want bytes.Buffer
+	runParseJobs(inputs, parser.Options{}, 3, nil, nil, &want)
+
+	streamText = true
+	defer func() { streamText = false }()
+	var got bytes.Buffer
+	summary := runParseJobs(inputs, parser.Options{}, 3, nil, nil, &got)
+	if got.String() != want.String() {
+		t.Fatalf("expected streamed output to match:\n%q\ngot:\n%q", want.String(), got.String())
+	}
+	if summary.parsed != 6 || len(summary.errors) != 0 {
+		t.Fatalf("expected 6 pages parsed without errors, got %d and %v", summary.parsed, summary.errors)
+	}
+}
+
+func TestOrderedOutput(t *testing.T) {
+	var out bytes.Buffer
+	o := newOrderedOutput(&out)
+
+	// a page ahead of its turn is held, the current one goes through
+	fmt.Fprint(o.writer(1), "b")
+	fmt.Fprint(o.writer(0), "a")
+	if out.String() != "a" {
+		t.Fatalf("expected only the current page, got %q", out.String())
+	}
+	o.done(1)
+	if out.String() != "a" {
+		t.Fatalf("expected a finished page to wait for its turn, got %q", out.String())
+	}
+	o.done(0)
+	fmt.Fprint(o.writer(2), "c")
+	if out.String() != "abc" {
+		t.Fatalf("expected pages in input order, got %q", out.String())
+	}
+}
+
+func TestCollectStdin(t *testing.T) {
+	page := filepath.Join(t.TempDir(), "stdin.html")
+	if err := os.WriteFile(page, []byte("<main><p>From stdin.</p></main>"), 0o644); err != nil {
+		t.Fatalf("failed to write page: %v", err)
+	}
+	stdin, err := os.Open(page)
+	if err != nil {
+		t.Fatalf("failed to open page: %v", err)
+	}
+	defer stdin.Close()
+	saved := os.Stdin
+	os.Stdin = stdin
+	defer func() { os.Stdin = saved }()
+
+	// stdin is read once however often "-" is given
+	inputs, errs := collectParseInputs([]string{stdinPath, stdinPath})
+	if len(errs) != 0 || len(inputs) != 1 {
+		t.Fatalf("expected one input without errors, got %d and %v", len(inputs), errs)
+	}
+	if in := inputs[0]; in.key() != stdinPath || in.name() != stdinName {
+		t.Fatalf("unexpected stdin input %q named %q", in.key(), in.name())
+	}
+
+	var out bytes.Buffer
+	summary := runParseJobs(inputs, parser.Options{}, 1, nil, nil, &out)
+	if got := strings.TrimSpace(out.String()); got != "From stdin." || summary.parsed != 1 {
+		t.Fatalf("unexpected stdin output %q", got)
+	}
+}
+
+func TestCommonDir(t *testing.T) {
+	for _, tt := range []struct {
+		names []string
+		want  string
+	}{
+		{nil, ""},
+		{[]string{"index.html"}, ""},
+		{[]string{"book/index.html"}, "book/"},
+		{[]string{"book/ch01.html", "book/sub/ch02.html"}, "book/"},
+		{[]string{"book/en/ch01.html", "book/en/ch02.html"}, "book/en/"},
+		{[]string{"book/ch01.html", "bookish/ch02.html"}, ""},
+		{[]string{"a/b/ch01.html", "a/c/ch02.html", "index.html"}, ""},
+	} {
+		if got := commonDir(tt.names); got != tt.want {
+			t.Errorf("commonDir(%q) = %q, want %q", tt.names, got, tt.want)
+		}
+	}
+}
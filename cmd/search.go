@@ -3,20 +3,178 @@ package cmd
 import (
 	"fmt"
 	"log"
+	"os"
+	"ruborag/internal/chunker"
 	"ruborag/internal/db"
 	"ruborag/internal/embedding"
+	"ruborag/internal/parser"
 	"ruborag/internal/similarity"
+	"ruborag/internal/snippet"
 	"sort"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
 
 var topK int
+var showSeeAlso bool
+var searchManifest string
+var expand string
+var showContent bool
+
+// snippetWidth is the length in bytes of the snippet shown for each result
+const snippetWidth = 240
 
 type searchResult struct {
 	SourceFile string
 	ChunkIndex int
 	Score      float32
+	// Origin names the figure or callout the chunk comes from, if any
+	Origin string
+	// Breadcrumb is the chapter and heading path of hierarchical chunks
+	Breadcrumb string
+	// Overlap is the number of bytes the chunk repeats from the previous one
+	Overlap int
+	// FirstChunk and LastChunk span the adjacent overlapping chunks merged
+	// into this result
+	FirstChunk int
+	LastChunk  int
+	// Siblings are the lower ranked results cut from the same parent,
+	// merged into this one with --expand parent
+	Siblings []searchResult
+	// ParentID is the parent passage stored with "embed --parents", or 0
+	ParentID int64
+	// Content is the chunk text
+	Content string
+	// Start and End are the byte offsets of the result in its source
+	// file; End is 0 for chunks embedded before offsets were recorded
+	Start int
+	End   int
+}
+
+// extend widens the offsets of r to cover those of other, which is being
+// merged into it
+func (r *searchResult) extend(other searchResult) {
+	if r.End == 0 || other.End == 0 {
+		return
+	}
+	r.Start = min(r.Start, other.Start)
+	r.End = max(r.End, other.End)
+}
+
+// chunkKey identifies a chunk of a file
+type chunkKey struct {
+	file  string
+	index int
+}
+
+// mergeOverlapping folds results for chunks that overlap a better ranked
+// result of the same file, directly before or after it, into that result,
+// so a passage split across overlapping chunks is listed once. results
+// must be sorted by score.
+func mergeOverlapping(results []searchResult) []searchResult {
+	overlaps := make(map[chunkKey]bool)
+	for _, r := range results {
+		if r.Overlap > 0 {
+			overlaps[chunkKey{r.SourceFile, r.ChunkIndex}] = true
+		}
+	}
+
+	// owner maps each chunk to the merged result it was folded into
+	owner := make(map[chunkKey]int)
+	merged := make([]searchResult, 0, len(results))
+	for _, r := range results {
+		k := chunkKey{r.SourceFile, r.ChunkIndex}
+		prev := chunkKey{r.SourceFile, r.ChunkIndex - 1}
+		next := chunkKey{r.SourceFile, r.ChunkIndex + 1}
+		if i, ok := owner[prev]; ok && overlaps[k] {
+			merged[i].LastChunk = max(merged[i].LastChunk, r.ChunkIndex)
+			merged[i].extend(r)
+			owner[k] = i
+			continue
+		}
+		if i, ok := owner[next]; ok && overlaps[next] {
+			merged[i].FirstChunk = min(merged[i].FirstChunk, r.ChunkIndex)
+			merged[i].extend(r)
+			owner[k] = i
+			continue
+		}
+		r.FirstChunk, r.LastChunk = r.ChunkIndex, r.ChunkIndex
+		owner[k] = len(merged)
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// mergeParents folds results whose chunks were cut from the same parent
+// passage into the best ranked of them, so each passage is listed once.
+// results must be sorted by score.
+func mergeParents(results []searchResult) []searchResult {
+	seen := make(map[int64]int)
+	merged := make([]searchResult, 0, len(results))
+	for _, r := range results {
+		if r.ParentID != 0 {
+			if i, ok := seen[r.ParentID]; ok {
+				merged[i].Siblings = append(merged[i].Siblings, r)
+				continue
+			}
+			seen[r.ParentID] = len(merged)
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// chunkLabel names the chunks and byte offsets of r, in document order
+// with those of its siblings: "chunks 3-4, bytes 812-1630; chunk 7".
+func chunkLabel(r searchResult) string {
+	parts := append([]searchResult{r}, r.Siblings...)
+	sort.Slice(parts, func(i, j int) bool { return parts[i].FirstChunk < parts[j].FirstChunk })
+	labels := make([]string, len(parts))
+	for i, p := range parts {
+		labels[i] = fmt.Sprintf("chunk %d", p.FirstChunk)
+		if p.FirstChunk != p.LastChunk {
+			labels[i] = fmt.Sprintf("chunks %d-%d", p.FirstChunk, p.LastChunk)
+		}
+		if p.End > 0 {
+			labels[i] += fmt.Sprintf(", bytes %d-%d", p.Start, p.End)
+		}
+	}
+	return strings.Join(labels, "; ")
+}
+
+// joinedContent returns the text of r: the chunk text, or for chunks merged
+// by mergeOverlapping, the passage they cover, without the text each one
+// repeats from the one before. chunks holds every chunk by file and index.
+func joinedContent(r searchResult, chunks map[chunkKey]searchResult) string {
+	if r.FirstChunk == r.LastChunk {
+		return r.Content
+	}
+	parts := make([]chunker.Chunk, 0, r.LastChunk-r.FirstChunk+1)
+	for i := r.FirstChunk; i <= r.LastChunk; i++ {
+		c := chunks[chunkKey{r.SourceFile, i}]
+		parts = append(parts, chunker.Chunk{Text: c.Content, Overlap: c.Overlap})
+	}
+	return chunker.Join(parts)
+}
+
+// printPassage prints text indented under a result
+func printPassage(text string) {
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		fmt.Printf("   %s\n", line)
+	}
+}
+
+// highlightStyle highlights terms with ANSI colors when stdout is a
+// terminal, unless NO_COLOR is set, and with plain markers otherwise
+func highlightStyle() snippet.Style {
+	if os.Getenv("NO_COLOR") != "" {
+		return snippet.Plain
+	}
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		return snippet.ANSI
+	}
+	return snippet.Plain
 }
 
 var searchCmd = &cobra.Command{
@@ -28,6 +186,42 @@ using vector embeddings stored in the local SQLite index.
 It converts the query into an embedding, computes cosine similarity
 against all stored embeddings, and returns the most relevant results.
 
+Each result shows where it was found, as byte offsets in the embedded
+file, and a snippet of its text around the query's terms, highlighted in
+color on a terminal and as [[term]] otherwise (or when NO_COLOR is set).
+--show-content prints the whole chunk instead of the snippet, or for
+merged chunks (see below) the passage they cover.
+
+When the index was built from Markdown output (parse --format markdown),
+results that come from a figure, a note or a warning say so, e.g.
+"from figure 4-1" or "from a note".
+
+Chunks embedded with "ruborag embed --chunk-overlap" repeat part of their
+neighbours. When a chunk and the one before or after it both match, they
+are reported once, as "chunks 3-4", ranked by the better score, and
+--show-content prints them as one contiguous passage, without the text
+each chunk repeats from the one before.
+
+Chunks embedded with "ruborag embed --chunker hierarchical" are followed
+by their breadcrumb, e.g. "Chapter 4 › What Is Ownership? › Variable Scope".
+
+Chunks embedded with "ruborag embed --parents" are small for precise
+matching, and each links to the larger passage, such as the whole
+section, it was cut from. With --expand parent, results are listed once
+per parent passage, ranked by their best chunk, as "chunk 3; chunk 7"
+when several of its chunks match, and followed by the passage text
+instead of the snippet; results without a parent are followed by the
+chunk text.
+
+With --see-also, each result is followed by up to three pages it links to
+or is linked from, taken from the link graph stored by
+"ruborag parse --index-links".
+
+With --manifest pointing at the manifest.json of the parsed corpus, the
+query is normalized like the parsed text (parse --unicode,
+--fold-punctuation, --collapse-spaces), so "Rust’s" and "Rust's" find the
+same chunks.
+
 Examples:
 
   # Basic semantic search
@@ -36,10 +230,35 @@ Examples:
   # Return top 10 results
   ruborag search --top-k 10 "what is ownership"
 
+  # Print the full text of each matching chunk
+  ruborag search --show-content "what is a slice"
+
+  # Normalize the query like the parsed corpus
+  ruborag search --manifest parsed/manifest.json "Rust’s ownership rules"
+
+  # Suggest related chapters next to each result
+  ruborag search --see-also "interior mutability"
+
+  # Match small chunks, but show the sections they come from
+  ruborag search --expand parent "what is a dangling reference"
+
 `,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		query := args[0]
+		if expand != "" && expand != "parent" {
+			log.Fatalf("unknown --expand %q (want parent)", expand)
+		}
+		if searchManifest != "" {
+			if _, err := os.Stat(searchManifest); err != nil {
+				log.Fatalf("failed to open manifest: %v", err)
+			}
+			manifest, err := parser.LoadManifest(searchManifest)
+			if err != nil {
+				log.Fatalf("failed to load manifest: %v", err)
+			}
+			query = manifest.Normalization.Apply(query)
+		}
 
 		queryVec, err := embedding.EmbedChunk(query)
 		if err != nil {
@@ -69,27 +288,78 @@ Examples:
 				SourceFile: e.SourceFile,
 				ChunkIndex: e.ChunkIndex,
 				Score:      score,
+				Origin:     parser.BlockOrigin(e.Content),
+				Overlap:    e.Overlap,
+				Breadcrumb: e.Breadcrumb,
+				ParentID:   e.ParentID,
+				Content:    e.Content,
+				Start:      e.Start,
+				End:        e.End,
 			})
 		}
 
+		byChunk := make(map[chunkKey]searchResult, len(results))
+		for _, r := range results {
+			byChunk[chunkKey{r.SourceFile, r.ChunkIndex}] = r
+		}
+
 		sort.Slice(results, func(i, j int) bool {
 			return results[i].Score > results[j].Score
 		})
+		results = mergeOverlapping(results)
+		if expand == "parent" {
+			results = mergeParents(results)
+		}
 
 		if topK > len(results) {
 			topK = len(results)
 		}
 
+		terms := snippet.NewMatcher(query)
+		style := highlightStyle()
+
 		fmt.Printf("Top %d results:\n\n", topK)
 		for i := 0; i < topK; i++ {
 			r := results[i]
+			origin := ""
+			if r.Origin != "" {
+				origin = ", from " + r.Origin
+			}
 			fmt.Printf(
-				"%d. %s (chunk %d) — score: %.4f\n",
+				"%d. %s (%s%s) — score: %.4f\n",
 				i+1,
 				r.SourceFile,
-				r.ChunkIndex,
+				chunkLabel(r),
+				origin,
 				r.Score,
 			)
+			if r.Breadcrumb != "" {
+				fmt.Printf("   %s\n", r.Breadcrumb)
+			}
+
+			switch {
+			case expand == "parent" && r.ParentID != 0:
+				parent, err := database.GetParent(r.ParentID)
+				if err != nil {
+					log.Fatalf("failed to load parent passage: %v", err)
+				}
+				printPassage(terms.Highlight(parent.Content, style))
+			case expand == "parent" || showContent:
+				printPassage(terms.Highlight(joinedContent(r, byChunk), style))
+			default:
+				fmt.Printf("   %s\n", terms.Snippet(r.Content, snippetWidth, style))
+			}
+
+			if showSeeAlso {
+				pages, err := seeAlso(database, parser.PageName(r.SourceFile), 3)
+				if err != nil {
+					log.Fatalf("failed to load links: %v", err)
+				}
+				if len(pages) > 0 {
+					fmt.Printf("   see also: %s\n", strings.Join(pages, ", "))
+				}
+			}
+			fmt.Println()
 		}
 	},
 }
@@ -104,4 +374,8 @@ func init() {
 		5,
 		"Number of top results to return",
 	)
+	searchCmd.Flags().BoolVar(&showSeeAlso, "see-also", false, "Show pages linked from or to each result")
+	searchCmd.Flags().BoolVar(&showContent, "show-content", false, "Print the full text of each result instead of a snippet")
+	searchCmd.Flags().StringVar(&expand, "expand", "", "Show the text of each result: parent shows the passage its chunk was cut from")
+	searchCmd.Flags().StringVar(&searchManifest, "manifest", "", "Parse manifest whose text normalization is applied to the query")
 }
@@ -0,0 +1,56 @@
+package cmd
+
+import "testing"
+
+func TestMergeOverlapping(t *testing.T) {
+	// three chunks of one file, each repeating the end of the one before
+	chunks := []searchResult{
+		{SourceFile: "ch04.md", ChunkIndex: 0, Content: "Each value has an owner."},
+		{SourceFile: "ch04.md", ChunkIndex: 1, Content: "an owner. There can only be one.", Overlap: 9},
+		{SourceFile: "ch04.md", ChunkIndex: 2, Content: "only be one. The value is dropped.", Overlap: 12},
+		{SourceFile: "ch05.md", ChunkIndex: 0, Content: "Structs."},
+	}
+	byChunk := make(map[chunkKey]searchResult)
+	for _, c := range chunks {
+		byChunk[chunkKey{c.SourceFile, c.ChunkIndex}] = c
+	}
+
+	// ranked by score: chunk 1 first, chunk 2 and 0 fold into it
+	results := mergeOverlapping([]searchResult{chunks[1], chunks[3], chunks[2], chunks[0]})
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %+v", results)
+	}
+	r := results[0]
+	if r.ChunkIndex != 1 || r.FirstChunk != 0 || r.LastChunk != 2 {
+		t.Fatalf("expected chunks 0-2 ranked by chunk 1, got %+v", r)
+	}
+	if got, want := joinedContent(r, byChunk), "Each value has an owner. There can only be one. The value is dropped."; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	if got := joinedContent(results[1], byChunk); got != "Structs." {
+		t.Fatalf("expected an unmerged chunk's own text, got %q", got)
+	}
+}
+
+func TestMergeParents(t *testing.T) {
+	// chunks 3 and 7 of one parent match, with chunk 5 of another between
+	results := mergeParents([]searchResult{
+		{SourceFile: "ch04.md", ChunkIndex: 7, FirstChunk: 7, LastChunk: 7, ParentID: 1, Start: 900, End: 1000},
+		{SourceFile: "ch04.md", ChunkIndex: 5, FirstChunk: 5, LastChunk: 5, ParentID: 2, Start: 600, End: 700},
+		{SourceFile: "ch04.md", ChunkIndex: 3, FirstChunk: 2, LastChunk: 3, ParentID: 1, Start: 200, End: 400},
+		{SourceFile: "ch05.md", ChunkIndex: 0, FirstChunk: 0, LastChunk: 0},
+	})
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %+v", results)
+	}
+	r := results[0]
+	if r.ChunkIndex != 7 || r.FirstChunk != 7 || r.LastChunk != 7 || r.Start != 900 || r.End != 1000 {
+		t.Fatalf("expected chunk 7 not to be widened, got %+v", r)
+	}
+	if got, want := chunkLabel(r), "chunks 2-3, bytes 200-400; chunk 7, bytes 900-1000"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	if got := chunkLabel(results[2]); got != "chunk 0" {
+		t.Fatalf("unexpected label %q", got)
+	}
+}
@@ -0,0 +1,270 @@
+// Package chunker splits parsed documents into the chunks that are embedded
+// and searched.
+package chunker
+
+import (
+	"fmt"
+	"ruborag/internal/tokenizer"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Strategy names a way of splitting a document.
+type Strategy string
+
+const (
+	// StrategyFixed cuts every Size characters, wherever that falls.
+	StrategyFixed Strategy = "fixed"
+	// StrategySentence packs whole sentences into chunks of up to Size
+	// characters.
+	StrategySentence Strategy = "sentence"
+	// StrategyParagraph packs whole paragraphs (blocks separated by blank
+	// lines) into chunks of up to Size characters.
+	StrategyParagraph Strategy = "paragraph"
+	// StrategySection makes one chunk per Markdown section, from a heading
+	// to the next one.
+	StrategySection Strategy = "section"
+	// StrategyCode packs paragraphs, keeping each fenced code block whole
+	// with the paragraph that introduces it.
+	StrategyCode Strategy = "code"
+	// StrategyHierarchical splits like StrategySection and records the
+	// headings each chunk falls under as its breadcrumb.
+	StrategyHierarchical Strategy = "hierarchical"
+	// StrategySemantic splits where the meaning of consecutive sentences
+	// shifts, as judged by their embeddings; see Semantic.
+	StrategySemantic Strategy = "semantic"
+)
+
+// ParseStrategy validates a strategy name given on the command line.
+func ParseStrategy(s string) (Strategy, error) {
+	switch Strategy(s) {
+	case "", StrategyFixed:
+		return StrategyFixed, nil
+	case StrategySentence, StrategyParagraph, StrategySection, StrategyCode, StrategyHierarchical, StrategySemantic:
+		return Strategy(s), nil
+	}
+	return "", fmt.Errorf("unknown chunker %q (want fixed, sentence, paragraph, section, code, hierarchical or semantic)", s)
+}
+
+// Chunk is a piece of a document.
+type Chunk struct {
+	Text string
+	// Start and End are the byte offsets of Text in the document:
+	// Text == doc[Start:End].
+	Start int
+	End   int
+	// Strategy is the strategy that produced the chunk.
+	Strategy Strategy
+	// Overlap is the number of bytes at the start of Text that repeat the
+	// end of the previous chunk; see WithOverlap.
+	Overlap int
+	// Breadcrumb locates the chunk in the document, e.g. "What Is
+	// Ownership? › Variable Scope"; it is embedded with the chunk but is
+	// not part of Text.
+	Breadcrumb string
+	// OpenFence and CloseFence complete a piece of a code listing too
+	// long for one chunk (see Code): the listing's opening fence line,
+	// info string included, when the piece starts after it, and its
+	// closing fence when the piece ends before it. Like the breadcrumb,
+	// they are embedded with the chunk but are not part of Text.
+	OpenFence  string
+	CloseFence string
+}
+
+// Fenced returns the chunk text within the fences of its listing, if it is
+// a piece of one.
+func (c Chunk) Fenced() string {
+	text := c.Text
+	if c.OpenFence != "" {
+		text = c.OpenFence + "\n" + text
+	}
+	if c.CloseFence != "" {
+		text += "\n" + c.CloseFence
+	}
+	return text
+}
+
+// Embedded returns the text to embed for the chunk: the breadcrumb, if
+// any, on a line of its own before the fenced chunk text.
+func (c Chunk) Embedded() string {
+	if c.Breadcrumb == "" {
+		return c.Fenced()
+	}
+	return c.Breadcrumb + "\n\n" + c.Fenced()
+}
+
+// Chunker splits a document into chunks, in document order. Only
+// chunkers that call out, such as Semantic, which embeds the document,
+// return an error.
+type Chunker interface {
+	Chunk(doc string) ([]Chunk, error)
+}
+
+// New returns the chunker for strategy. size is the maximum chunk length
+// in characters (runes); units larger than size, such as a very long
+// paragraph, are split further with the next finer strategy, down to
+// fixed cuts.
+func New(strategy Strategy, size int) (Chunker, error) {
+	return NewCounted(strategy, size, nil)
+}
+
+// NewCounted is like New, but measures size with counter, so that with a
+// token counter chunks fit a model's input limit. A nil counter counts
+// characters.
+func NewCounted(strategy Strategy, size int, counter tokenizer.Counter) (Chunker, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("chunk size must be positive, got %d", size)
+	}
+	switch strategy {
+	case "", StrategyFixed:
+		return Fixed{Size: size, Counter: counter}, nil
+	case StrategySentence:
+		return Sentence{Size: size, Counter: counter}, nil
+	case StrategyParagraph:
+		return Paragraph{Size: size, Counter: counter}, nil
+	case StrategySection:
+		return Section{Size: size, Counter: counter}, nil
+	case StrategyCode:
+		return Code{Size: size, Counter: counter}, nil
+	case StrategyHierarchical:
+		return Hierarchical{Size: size, Counter: counter}, nil
+	case StrategySemantic:
+		return nil, fmt.Errorf("the %s chunker needs an embedding function; build a Semantic", strategy)
+	}
+	return nil, fmt.Errorf("unknown chunker %q", strategy)
+}
+
+// span is a byte range of the document.
+type span struct {
+	start, end int
+}
+
+// splitFunc divides a span into smaller units, trimmed of surrounding
+// whitespace, dropping empty ones.
+type splitFunc func(doc string, s span) []span
+
+// budget is the maximum length of a chunk: size characters, or size tokens
+// of counter when it is set.
+type budget struct {
+	size    int
+	counter tokenizer.Counter
+}
+
+// fits reports whether s is within the budget.
+func (b budget) fits(doc string, s span) bool {
+	return b.length(doc, s) <= b.size
+}
+
+// length measures s in the unit of the budget.
+func (b budget) length(doc string, s span) int {
+	return b.measure(doc[s.start:s.end])
+}
+
+// measure returns the length of text in the unit of the budget.
+func (b budget) measure(text string) int {
+	if b.counter == nil {
+		return utf8.RuneCountInString(text)
+	}
+	return b.counter.Count(text)
+}
+
+// less returns what is left of the budget once text is set aside, at
+// least one character or token.
+func (b budget) less(text string) budget {
+	return budget{max(1, b.size-b.measure(text)), b.counter}
+}
+
+// sized is implemented by the chunkers that keep the embedded text of
+// their chunks within a budget, so that text added to their chunks
+// afterwards, such as an overlap or an inherited breadcrumb, can be left
+// room for.
+type sized interface {
+	Chunker
+	limit() budget
+	// resized returns a copy of the chunker with the given size.
+	resized(size int) Chunker
+}
+
+// Reserve returns c leaving room in every chunk for text that will be
+// added to its embedded text after chunking, such as a breadcrumb prefix.
+// Chunkers without a size are returned as they are.
+func Reserve(c Chunker, text string) Chunker {
+	if o, ok := c.(overlapping); ok {
+		return overlapping{Reserve(o.Chunker, text), o.overlap}
+	}
+	s, ok := c.(sized)
+	if !ok || text == "" {
+		return c
+	}
+	return s.resized(s.limit().less(text).size)
+}
+
+// pack turns units into chunk spans that fit b. With merge, consecutive
+// units are joined while they fit. Units that do not fit are divided with
+// the first of finer, and so on, with fixed cuts last.
+func pack(doc string, units []span, b budget, merge bool, finer []splitFunc) []span {
+	var out []span
+	cur := span{-1, -1}
+	flush := func() {
+		if cur.start >= 0 {
+			out = append(out, cur)
+			cur = span{-1, -1}
+		}
+	}
+
+	for _, u := range units {
+		if !b.fits(doc, u) {
+			flush()
+			if len(finer) == 0 {
+				out = append(out, fixedSpans(doc, u, b)...)
+			} else {
+				out = append(out, pack(doc, finer[0](doc, u), b, true, finer[1:])...)
+			}
+			continue
+		}
+		if merge && cur.start >= 0 && b.fits(doc, span{cur.start, u.end}) {
+			cur.end = u.end
+			continue
+		}
+		flush()
+		cur = u
+	}
+	flush()
+	return out
+}
+
+func chunks(doc string, spans []span, strategy Strategy) []Chunk {
+	out := make([]Chunk, len(spans))
+	for i, s := range spans {
+		out[i] = Chunk{Text: doc[s.start:s.end], Start: s.start, End: s.end, Strategy: strategy}
+	}
+	return out
+}
+
+// trim shrinks s to exclude leading and trailing whitespace; ok is false
+// when nothing is left.
+func trim(doc string, s span) (span, bool) {
+	for s.start < s.end {
+		r, n := utf8.DecodeRuneInString(doc[s.start:s.end])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		s.start += n
+	}
+	for s.end > s.start {
+		r, n := utf8.DecodeLastRuneInString(doc[s.start:s.end])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		s.end -= n
+	}
+	return s, s.start < s.end
+}
+
+// appendTrimmed appends the trimmed s to spans unless it is blank.
+func appendTrimmed(spans []span, doc string, s span) []span {
+	if t, ok := trim(doc, s); ok {
+		spans = append(spans, t)
+	}
+	return spans
+}
@@ -0,0 +1,651 @@
+package chunker_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"ruborag/internal/chunker"
+	"ruborag/internal/tokenizer"
+)
+
+// OwnershipText is the start of ch04-01 as ruborag parse writes it in the
+// default text format: one line of prose.
+const OwnershipText = `What Is Ownership? Ownership is a set of rules that govern how a Rust program manages memory. ` +
+	`All programs have to manage the way they use a computer’s memory while running. ` +
+	`Some languages have garbage collection that regularly looks for no-longer-used memory as the program runs; ` +
+	`in other languages, the programmer must explicitly allocate and free the memory. ` +
+	`Rust uses a third approach: memory is managed through a system of ownership with a set of rules that the compiler checks. ` +
+	`If any of the rules are violated, the program won’t compile. ` +
+	`None of the features of ownership will slow down your program while it’s running. ` +
+	`Keep at it! Smart pointers (e.g. Box<T>) are covered in Chapter 15.`
+
+// OwnershipMarkdown is part of ch04-01 as ruborag parse --format markdown
+// writes it.
+const OwnershipMarkdown = `## What Is Ownership?
+
+*Ownership* is a set of rules that govern how a Rust program manages memory. All programs have to manage the way they use a computer’s memory while running.
+
+Because ownership is a new concept for many programmers, it does take some time to get used to. Keep at it!
+
+### Ownership Rules
+
+First, let’s take a look at the ownership rules:
+
+- Each value in Rust has an *owner*.
+- There can only be one owner at a time.
+- When the owner goes out of scope, the value will be dropped.
+
+### Variable Scope
+
+Listing 4-1 shows a program with comments annotating where the variable ` + "`s`" + ` would be valid.
+
+` + "```rust" + `
+    {                      // s is not valid here, it’s not yet declared
+        let s = "hello";   // s is valid from this point forward
+
+        // do stuff with s
+    }                      // this scope is now over, and s is no longer valid
+` + "```" + `
+
+Listing 4-1: A variable and the scope in which it is valid
+`
+
+// checkChunks verifies the invariants every strategy keeps: offsets point
+// at the chunk text, chunks are in order without overlap, no chunk is
+// longer than size, and the strategy is recorded.
+func checkChunks(t *testing.T, doc string, chunks []chunker.Chunk, size int, strategy chunker.Strategy) {
+	t.Helper()
+	if len(chunks) == 0 {
+		t.Fatal("expected chunks")
+	}
+	prevEnd := 0
+	for i, c := range chunks {
+		if c.Start < prevEnd || c.End <= c.Start || c.End > len(doc) {
+			t.Fatalf("chunk %d: bad offsets [%d, %d) after %d", i, c.Start, c.End, prevEnd)
+		}
+		if doc[c.Start:c.End] != c.Text {
+			t.Fatalf("chunk %d: text %q does not match offsets [%d, %d)", i, c.Text, c.Start, c.End)
+		}
+		if n := utf8.RuneCountInString(c.Text); n > size {
+			t.Fatalf("chunk %d: %d characters, want at most %d", i, n, size)
+		}
+		if c.Strategy != strategy {
+			t.Fatalf("chunk %d: strategy %q, want %q", i, c.Strategy, strategy)
+		}
+		prevEnd = c.End
+	}
+}
+
+// newChunker returns the chunker for strategy, failing the test if there
+// is none.
+func newChunker(t *testing.T, strategy chunker.Strategy, size int) chunker.Chunker {
+	t.Helper()
+	c, err := chunker.New(strategy, size)
+	if err != nil {
+		t.Fatalf("failed to create %s chunker: %v", strategy, err)
+	}
+	return c
+}
+
+// chunk splits doc with c, failing the test on an error.
+func chunk(t *testing.T, c chunker.Chunker, doc string) []chunker.Chunk {
+	t.Helper()
+	chunks, err := c.Chunk(doc)
+	if err != nil {
+		t.Fatalf("failed to chunk: %v", err)
+	}
+	return chunks
+}
+
+func texts(chunks []chunker.Chunk) []string {
+	out := make([]string, len(chunks))
+	for i, c := range chunks {
+		out[i] = c.Text
+	}
+	return out
+}
+
+func TestFixed(t *testing.T) {
+	c, err := chunker.New(chunker.StrategyFixed, 100)
+	if err != nil {
+		t.Fatalf("failed to create chunker: %v", err)
+	}
+	chunks := chunk(t, c, OwnershipText)
+	checkChunks(t, OwnershipText, chunks, 100, chunker.StrategyFixed)
+
+	// every character is kept, cut every 100 runes
+	if strings.Join(texts(chunks), "") != OwnershipText {
+		t.Fatal("expected fixed chunks to cover the document")
+	}
+	runes := []rune(OwnershipText)
+	if len(chunks) != (len(runes)+99)/100 {
+		t.Fatalf("expected %d chunks, got %d", (len(runes)+99)/100, len(chunks))
+	}
+	if chunks[1].Text != string(runes[100:200]) {
+		t.Fatalf("unexpected second chunk %q", chunks[1].Text)
+	}
+
+	// cuts of whitespace only are not worth embedding
+	doc := "Moves." + strings.Repeat(" ", 12) + "\n\nClones."
+	if got := texts(chunk(t, newChunker(t, chunker.StrategyFixed, 6), doc)); strings.Join(got, "|") != "Moves.|\n\nClon|es." {
+		t.Fatalf("expected blank cuts to be dropped, got %q", got)
+	}
+}
+
+func TestSentence(t *testing.T) {
+	c, err := chunker.New(chunker.StrategySentence, 200)
+	if err != nil {
+		t.Fatalf("failed to create chunker: %v", err)
+	}
+	chunks := chunk(t, c, OwnershipText)
+	checkChunks(t, OwnershipText, chunks, 200, chunker.StrategySentence)
+
+	for i, c := range chunks {
+		last := c.Text[len(c.Text)-1]
+		if last != '.' && last != '!' && last != '?' {
+			t.Fatalf("chunk %d ends mid-sentence: %q", i, c.Text)
+		}
+	}
+	if !strings.HasPrefix(chunks[0].Text, "What Is Ownership? Ownership is a set of rules") {
+		t.Fatalf("unexpected first chunk %q", chunks[0].Text)
+	}
+	// "e.g." does not end a sentence
+	last := chunks[len(chunks)-1].Text
+	if !strings.HasSuffix(last, "Smart pointers (e.g. Box<T>) are covered in Chapter 15.") {
+		t.Fatalf("expected the last sentence in one piece, got %q", last)
+	}
+
+	// a sentence longer than the chunk size is cut
+	long := newChunker(t, chunker.StrategySentence, 40)
+	checkChunks(t, OwnershipText, chunk(t, long, OwnershipText), 40, chunker.StrategySentence)
+}
+
+func TestParagraph(t *testing.T) {
+	c, err := chunker.New(chunker.StrategyParagraph, 400)
+	if err != nil {
+		t.Fatalf("failed to create chunker: %v", err)
+	}
+	chunks := chunk(t, c, OwnershipMarkdown)
+	checkChunks(t, OwnershipMarkdown, chunks, 400, chunker.StrategyParagraph)
+
+	// the listing is not split at its blank line
+	var listing string
+	for _, c := range chunks {
+		if strings.Contains(c.Text, "```rust") {
+			listing = c.Text
+		}
+	}
+	if !strings.Contains(listing, "// do stuff with s") || !strings.Contains(listing, "no longer valid\n```") {
+		t.Fatalf("expected the whole listing in one chunk, got %q", listing)
+	}
+	for i, c := range chunks {
+		if strings.HasPrefix(c.Text, "- There can only") {
+			t.Fatalf("chunk %d starts inside the list", i)
+		}
+	}
+
+	// text output has no blank lines and is packed by sentence
+	text := chunk(t, c, OwnershipText)
+	checkChunks(t, OwnershipText, text, 400, chunker.StrategyParagraph)
+	if len(text) < 2 || !strings.HasSuffix(text[0].Text, ".") {
+		t.Fatalf("expected text to be packed by sentence, got %q", texts(text))
+	}
+}
+
+func TestSection(t *testing.T) {
+	c, err := chunker.New(chunker.StrategySection, 1000)
+	if err != nil {
+		t.Fatalf("failed to create chunker: %v", err)
+	}
+	chunks := chunk(t, c, OwnershipMarkdown)
+	checkChunks(t, OwnershipMarkdown, chunks, 1000, chunker.StrategySection)
+
+	want := []string{"## What Is Ownership?", "### Ownership Rules", "### Variable Scope"}
+	if len(chunks) != len(want) {
+		t.Fatalf("expected %d sections, got %d: %q", len(want), len(chunks), texts(chunks))
+	}
+	for i, heading := range want {
+		if !strings.HasPrefix(chunks[i].Text, heading+"\n") {
+			t.Fatalf("section %d: expected heading %q, got %q", i, heading, chunks[i].Text)
+		}
+	}
+	if !strings.HasSuffix(chunks[2].Text, "in which it is valid") {
+		t.Fatalf("expected the listing caption in the last section, got %q", chunks[2].Text)
+	}
+
+	// a long section is packed by paragraph, never across sections
+	small := newChunker(t, chunker.StrategySection, 200)
+	split := chunk(t, small, OwnershipMarkdown)
+	checkChunks(t, OwnershipMarkdown, split, 200, chunker.StrategySection)
+	for i, c := range split {
+		if strings.Contains(c.Text[1:], "\n#") {
+			t.Fatalf("chunk %d spans sections: %q", i, c.Text)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := chunker.New(chunker.StrategySentence, 0); err == nil {
+		t.Fatal("expected an error for a zero chunk size")
+	}
+	if _, err := chunker.ParseStrategy("token"); err == nil {
+		t.Fatal("expected an error for an unknown strategy")
+	}
+	if s, err := chunker.ParseStrategy(""); err != nil || s != chunker.StrategyFixed {
+		t.Fatalf("expected fixed by default, got %q, %v", s, err)
+	}
+	for _, s := range []chunker.Strategy{chunker.StrategyFixed, chunker.StrategySentence, chunker.StrategyParagraph, chunker.StrategySection} {
+		c, err := chunker.New(s, 100)
+		if err != nil {
+			t.Fatalf("failed to create chunker: %v", err)
+		}
+		if chunks := chunk(t, c, "  \n\n "); len(chunks) != 0 {
+			t.Fatalf("%s: expected no chunks for blank text, got %q", s, texts(chunks))
+		}
+	}
+}
+
+func TestOverlap(t *testing.T) {
+	if o, err := chunker.ParseOverlap("200"); err != nil || o != (chunker.Overlap{Size: 200, Unit: chunker.OverlapCharacters}) {
+		t.Fatalf("unexpected overlap %+v, %v", o, err)
+	}
+	if o, err := chunker.ParseOverlap("2s"); err != nil || o != (chunker.Overlap{Size: 2, Unit: chunker.OverlapSentences}) {
+		t.Fatalf("unexpected overlap %+v, %v", o, err)
+	}
+	for _, s := range []string{"s", "-1", "ten"} {
+		if _, err := chunker.ParseOverlap(s); err == nil {
+			t.Fatalf("expected an error for %q", s)
+		}
+	}
+
+	base := newChunker(t, chunker.StrategySentence, 200)
+	plain := chunk(t, base, OwnershipText)
+	if c := chunker.WithOverlap(base, chunker.Overlap{}); len(chunk(t, c, OwnershipText)) != len(plain) {
+		t.Fatal("expected no overlap to leave chunks unchanged")
+	}
+
+	// one sentence: each chunk starts with the end of the last sentence of
+	// the one before, and the overlap counts toward the chunk size
+	sentences := chunk(t, chunker.WithOverlap(base, chunker.Overlap{Size: 1, Unit: chunker.OverlapSentences}), OwnershipText)
+	if len(sentences) < len(plain) {
+		t.Fatalf("expected at least %d chunks, got %d", len(plain), len(sentences))
+	}
+	for i := 1; i < len(sentences); i++ {
+		c, prev := sentences[i], OwnershipText[sentences[i-1].Start:sentences[i-1].End]
+		if c.Overlap == 0 || !strings.HasSuffix(prev, c.Text[:c.Overlap]) {
+			t.Fatalf("chunk %d: overlap %d does not repeat the previous chunk: %q", i, c.Overlap, c.Text)
+		}
+		if OwnershipText[c.Start:c.End] != c.Text {
+			t.Fatalf("chunk %d: bad offsets [%d, %d)", i, c.Start, c.End)
+		}
+		if last := strings.LastIndex(prev, ". "); last >= 0 && !strings.HasSuffix(prev[last+2:], c.Text[:c.Overlap]) {
+			t.Fatalf("chunk %d: expected the end of the last sentence %q, got %q", i, prev[last+2:], c.Text[:c.Overlap])
+		}
+		if n := utf8.RuneCountInString(c.Embedded()); n > 200 {
+			t.Fatalf("chunk %d: %d characters with its overlap, over the size of 200", i, n)
+		}
+	}
+
+	// characters: at most 50 repeated, starting at a word
+	fixed := newChunker(t, chunker.StrategyFixed, 200)
+	chars := chunk(t, chunker.WithOverlap(fixed, chunker.Overlap{Size: 50, Unit: chunker.OverlapCharacters}), OwnershipText)
+	for i := 1; i < len(chars); i++ {
+		c := chars[i]
+		if c.Overlap == 0 || utf8.RuneCountInString(c.Text[:c.Overlap]) > 50 {
+			t.Fatalf("chunk %d: unexpected overlap %d", i, c.Overlap)
+		}
+		if before := OwnershipText[c.Start-1]; before != ' ' {
+			t.Fatalf("chunk %d starts mid-word: %q", i, c.Text)
+		}
+		if n := utf8.RuneCountInString(c.Text); n > 200 {
+			t.Fatalf("chunk %d: %d characters with its overlap, over the size of 200", i, n)
+		}
+	}
+
+	// Join drops the repeated text and restores the document
+	for _, cs := range [][]chunker.Chunk{chars, texts2chunks(chunk(t, fixed, OwnershipText))} {
+		if got := chunker.Join(cs); got != OwnershipText {
+			t.Fatalf("Join did not restore the document:\n%q", got)
+		}
+	}
+	if got := chunker.Join(texts2chunks(sentences)); got != OwnershipText {
+		t.Fatalf("Join of sentence chunks did not restore the document:\n%q", got)
+	}
+}
+
+// texts2chunks keeps only what the index stores: the text and overlap.
+func texts2chunks(chunks []chunker.Chunk) []chunker.Chunk {
+	out := make([]chunker.Chunk, len(chunks))
+	for i, c := range chunks {
+		out[i] = chunker.Chunk{Text: c.Text, Overlap: c.Overlap}
+	}
+	return out
+}
+
+// RectangleMarkdown is Listing 5-15 of ch05-03 with the paragraphs around it.
+const RectangleMarkdown = `### Multiple impl Blocks
+
+Each struct is allowed to have multiple ` + "`impl`" + ` blocks. For example, Listing 5-15 is equivalent to the code shown in Listing 5-16, which has each method in its own ` + "`impl`" + ` block.
+
+` + "```rust" + `
+#[derive(Debug)]
+struct Rectangle {
+    width: u32,
+    height: u32,
+}
+
+impl Rectangle {
+    fn area(&self) -> u32 {
+        self.width * self.height
+    }
+}
+
+/// Checks whether other fits inside self.
+impl Rectangle {
+    fn can_hold(&self, other: &Rectangle) -> bool {
+        self.width > other.width && self.height > other.height
+    }
+}
+fn main() {
+    let rect1 = Rectangle {
+        width: 30,
+        height: 50,
+    };
+    println!("rect1 is {rect1:?}");
+}
+` + "```" + `
+
+Listing 5-16: Rewriting Listing 5-15 using multiple impl blocks
+
+There’s no reason to separate these methods into multiple ` + "`impl`" + ` blocks here, but this is valid syntax.
+`
+
+func TestCode(t *testing.T) {
+	c, err := chunker.New(chunker.StrategyCode, 1000)
+	if err != nil {
+		t.Fatalf("failed to create chunker: %v", err)
+	}
+	chunks := chunk(t, c, RectangleMarkdown)
+	checkChunks(t, RectangleMarkdown, chunks, 1000, chunker.StrategyCode)
+
+	// the listing fits: it stays whole with its introduction
+	var listing string
+	for _, c := range chunks {
+		if strings.Contains(c.Text, "```rust") {
+			listing = c.Text
+		}
+	}
+	if !strings.Contains(listing, "Each struct is allowed to have multiple") || !strings.Contains(listing, "}\n```") {
+		t.Fatalf("expected the whole listing with its introduction, got %q", listing)
+	}
+
+	// a listing longer than the chunk size is split between items
+	small := newChunker(t, chunker.StrategyCode, 300)
+	split := chunk(t, small, RectangleMarkdown)
+	checkChunks(t, RectangleMarkdown, split, 300, chunker.StrategyCode)
+
+	var code []string
+	for _, c := range split {
+		if strings.Contains(c.Text, "{") {
+			code = append(code, c.Text)
+		}
+	}
+	if len(code) < 3 {
+		t.Fatalf("expected the listing in several chunks, got %q", texts(split))
+	}
+	if !strings.Contains(code[0], "block.\n\n```rust\n#[derive(Debug)]") || !strings.Contains(code[0], "#[derive(Debug)]\nstruct Rectangle {") {
+		t.Fatalf("expected the introduction and the struct with its attribute first, got %q", code[0])
+	}
+	for i, c := range split {
+		// chunks end at the end of a line
+		if c.End < len(RectangleMarkdown) && RectangleMarkdown[c.End] != '\n' {
+			t.Fatalf("chunk %d ends mid-line: %q", i, c.Text)
+		}
+	}
+	for i, c := range code {
+		if strings.HasSuffix(c, "impl Rectangle {") {
+			t.Fatalf("piece %d ends inside an impl block: %q", i, c)
+		}
+		if strings.Contains(c, "fn can_hold") && !strings.Contains(c, "/// Checks whether other fits inside self.\nimpl Rectangle {") {
+			t.Fatalf("expected the doc comment with its impl block, got %q", c)
+		}
+	}
+	if last := code[len(code)-1]; !strings.HasPrefix(last, "fn main() {") {
+		t.Fatalf("expected fn main in a piece of its own, got %q", last)
+	}
+
+	// every piece of a split listing is fenced, with the info string
+	doc := "Two functions:\n\n```rust,ignore\nfn a() {\n    1\n}\n\nfn b() {\n    2\n}\n```\n\nAfter."
+	pieces := chunk(t, newChunker(t, chunker.StrategyCode, 50), doc)
+	checkChunks(t, doc, pieces, 50, chunker.StrategyCode)
+	var fenced []string
+	for _, c := range pieces {
+		fenced = append(fenced, c.Fenced())
+	}
+	want := []string{
+		"Two functions:",
+		"```rust,ignore\nfn a() {\n    1\n}\n```",
+		"```rust,ignore\nfn b() {\n    2\n}\n```",
+		"After.",
+	}
+	if strings.Join(fenced, "|") != strings.Join(want, "|") {
+		t.Fatalf("expected fenced pieces %q, got %q", want, fenced)
+	}
+	if e := pieces[1].Embedded(); e != want[1] {
+		t.Fatalf("expected the fence in the embedded text, got %q", e)
+	}
+	for i, c := range split {
+		if n := utf8.RuneCountInString(c.Embedded()); n > 300 {
+			t.Fatalf("piece %d: %d characters with its fences, want at most 300", i, n)
+		}
+	}
+	for i, c := range split {
+		if strings.Contains(c.Text, "{") && strings.Count(c.Fenced(), "```") != 2 {
+			t.Fatalf("piece %d is not fenced: %q", i, c.Fenced())
+		}
+	}
+
+	// an item longer than the chunk size is split between lines
+	tiny := newChunker(t, chunker.StrategyCode, 40)
+	for i, c := range chunk(t, tiny, RectangleMarkdown) {
+		if strings.Contains(c.Text, "self.width > other.width") && !strings.HasPrefix(c.Text, "        self.width") {
+			t.Fatalf("chunk %d: expected a line with its indentation, got %q", i, c.Text)
+		}
+	}
+}
+
+func TestHierarchical(t *testing.T) {
+	c, err := chunker.New(chunker.StrategyHierarchical, 1000)
+	if err != nil {
+		t.Fatalf("failed to create chunker: %v", err)
+	}
+	chunks := chunk(t, c, OwnershipMarkdown)
+	checkChunks(t, OwnershipMarkdown, chunks, 1000, chunker.StrategyHierarchical)
+
+	want := []string{
+		"What Is Ownership?",
+		"What Is Ownership? › Ownership Rules",
+		"What Is Ownership? › Variable Scope",
+	}
+	if len(chunks) != len(want) {
+		t.Fatalf("expected %d chunks, got %d", len(want), len(chunks))
+	}
+	for i, b := range want {
+		if chunks[i].Breadcrumb != b {
+			t.Fatalf("chunk %d: breadcrumb %q, want %q", i, chunks[i].Breadcrumb, b)
+		}
+	}
+	if got := chunks[1].Embedded(); got != want[1]+"\n\n"+chunks[1].Text {
+		t.Fatalf("unexpected embedded text %q", got)
+	}
+	if plain := (chunker.Chunk{Text: "moves"}); plain.Embedded() != "moves" {
+		t.Fatal("expected a chunk without breadcrumb to embed its text")
+	}
+
+	// pieces of a long section keep its breadcrumb; a sibling heading
+	// replaces the one before, and "#" lines in code are not headings
+	doc := "# Understanding Ownership\n\nIntro.\n\n## What Is Ownership?\n\n### The Stack\n\n" +
+		strings.Repeat("The stack is fast. ", 10) + "\n\n```rust\n# fn main() {\nlet x = 5;\n# }\n```\n\n" +
+		strings.Repeat("The heap is slower. ", 10) + "\n\n### Ownership Rules\n\nEach value has an owner.\n"
+	small := newChunker(t, chunker.StrategyHierarchical, 120)
+	got := map[string]string{}
+	for _, c := range chunk(t, small, doc) {
+		got[strings.SplitN(c.Text, " ", 3)[1]] = c.Breadcrumb
+		// the breadcrumb counts toward the chunk size
+		if n := utf8.RuneCountInString(c.Embedded()); n > 120 {
+			t.Fatalf("expected at most 120 characters with the breadcrumb, got %d: %q", n, c.Embedded())
+		}
+	}
+	for first, b := range map[string]string{
+		"Understanding": "Understanding Ownership",
+		"What":          "Understanding Ownership › What Is Ownership?",
+		"heap":          "Understanding Ownership › What Is Ownership? › The Stack",
+		"Ownership":     "Understanding Ownership › What Is Ownership? › Ownership Rules",
+	} {
+		if got[first] != b {
+			t.Errorf("chunk starting %q: breadcrumb %q, want %q", first, got[first], b)
+		}
+	}
+}
+
+func TestCounted(t *testing.T) {
+	counter := tokenizer.Heuristic{}
+	for _, s := range []chunker.Strategy{chunker.StrategyFixed, chunker.StrategySentence, chunker.StrategyParagraph, chunker.StrategySection, chunker.StrategyCode} {
+		c, err := chunker.NewCounted(s, 40, counter)
+		if err != nil {
+			t.Fatalf("failed to create chunker: %v", err)
+		}
+		chunks := chunk(t, c, OwnershipMarkdown)
+		checkChunks(t, OwnershipMarkdown, chunks, len(OwnershipMarkdown), s)
+		for i, chunk := range chunks {
+			if n := counter.Count(chunk.Text); n > 40 {
+				t.Fatalf("%s: chunk %d counts %d tokens, want at most 40: %q", s, i, n, chunk.Text)
+			}
+		}
+	}
+
+	// fixed cuts fill the budget and keep every character
+	c, err := chunker.NewCounted(chunker.StrategyFixed, 40, counter)
+	if err != nil {
+		t.Fatalf("failed to create chunker: %v", err)
+	}
+	chunks := chunk(t, c, OwnershipText)
+	if strings.Join(texts(chunks), "") != OwnershipText {
+		t.Fatal("expected counted fixed chunks to cover the document")
+	}
+	for i, chunk := range chunks[:len(chunks)-1] {
+		_, n := utf8.DecodeRuneInString(chunks[i+1].Text)
+		if next := OwnershipText[chunk.Start : chunks[i+1].Start+n]; counter.Count(next) <= 40 {
+			t.Fatalf("chunk %d: %q could have taken another character", i, chunk.Text)
+		}
+	}
+}
+
+func TestSemantic(t *testing.T) {
+	doc := "Ownership is a set of rules. Each value has an owner. The owner drops the value. " +
+		"Cargo is the Rust build system. Cargo downloads your dependencies. Run cargo build to compile."
+	// sentences about Cargo point one way, the others another
+	embed := func(texts []string) ([][]float32, error) {
+		vecs := make([][]float32, len(texts))
+		for i, text := range texts {
+			vecs[i] = []float32{1, 0}
+			if strings.Contains(strings.ToLower(text), "cargo") {
+				vecs[i] = []float32{0, 1}
+			}
+		}
+		return vecs, nil
+	}
+
+	c := &chunker.Semantic{Embed: embed, Window: 2, Percentile: 10, MaxSize: 1000}
+	chunks := chunk(t, c, doc)
+	checkChunks(t, doc, chunks, 1000, chunker.StrategySemantic)
+	want := []string{
+		"Ownership is a set of rules. Each value has an owner. The owner drops the value.",
+		"Cargo is the Rust build system. Cargo downloads your dependencies. Run cargo build to compile.",
+	}
+	if got := texts(chunks); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("expected a split at the topic shift, got %q", got)
+	}
+
+	breaks, threshold, err := c.Breakpoints(doc)
+	if err != nil {
+		t.Fatalf("failed to find breakpoints: %v", err)
+	}
+	if len(breaks) != 5 || !breaks[2].Shift || !breaks[2].Split || breaks[2].Similarity >= threshold {
+		t.Fatalf("expected the third boundary to be the only shift, got %+v (threshold %v)", breaks, threshold)
+	}
+
+	// a chunk under MinSize does not end at a shift
+	c = &chunker.Semantic{Embed: embed, Percentile: 10, MinSize: 100, MaxSize: 1000}
+	if chunks := chunk(t, c, doc); len(chunks) != 1 {
+		t.Fatalf("expected one chunk under the minimum size, got %q", texts(chunks))
+	}
+
+	// MaxSize ends chunks before the shift too
+	c = &chunker.Semantic{Embed: embed, Percentile: 10, MaxSize: 60}
+	chunks = chunk(t, c, doc)
+	checkChunks(t, doc, chunks, 60, chunker.StrategySemantic)
+	if len(chunks) != 5 || chunks[1].Text != "The owner drops the value." {
+		t.Fatalf("expected the first two sentences together, then one per chunk, got %q", texts(chunks))
+	}
+
+	// embedding errors stop chunking, also through WithOverlap
+	failing := &chunker.Semantic{
+		Embed:   func([]string) ([][]float32, error) { return nil, errors.New("quota exceeded") },
+		MaxSize: 1000,
+	}
+	overlapped := chunker.WithOverlap(failing, chunker.Overlap{Size: 1, Unit: chunker.OverlapSentences})
+	chunks, err = overlapped.Chunk(doc)
+	if err == nil || err.Error() != "quota exceeded" {
+		t.Fatalf("expected the embedding error, got %v", err)
+	}
+	if len(chunks) != 0 {
+		t.Fatalf("expected no chunks, got %q", texts(chunks))
+	}
+	if _, err := chunker.New(chunker.StrategySemantic, 100); err == nil {
+		t.Fatal("expected New to refuse the semantic chunker")
+	}
+}
+
+func TestNest(t *testing.T) {
+	parent := newChunker(t, chunker.StrategyHierarchical, 2000)
+	child := newChunker(t, chunker.StrategySentence, 80)
+	families, err := chunker.Nest(OwnershipMarkdown, parent, child)
+	if err != nil {
+		t.Fatalf("failed to nest chunks: %v", err)
+	}
+	if len(families) != 3 {
+		t.Fatalf("expected a family per section, got %d", len(families))
+	}
+
+	var children []chunker.Chunk
+	for _, f := range families {
+		for _, c := range f.Children {
+			if c.Start < f.Parent.Start || c.End > f.Parent.End {
+				t.Fatalf("child [%d, %d) outside its parent [%d, %d)", c.Start, c.End, f.Parent.Start, f.Parent.End)
+			}
+			if c.Breadcrumb != f.Parent.Breadcrumb {
+				t.Fatalf("expected child breadcrumb %q, got %q", f.Parent.Breadcrumb, c.Breadcrumb)
+			}
+			if n := utf8.RuneCountInString(c.Embedded()); n > 80 {
+				t.Fatalf("expected at most 80 characters with the parent breadcrumb, got %d: %q", n, c.Embedded())
+			}
+		}
+		children = append(children, f.Children...)
+	}
+	checkChunks(t, OwnershipMarkdown, children, 80, chunker.StrategySentence)
+	if got := families[1].Children[0]; !strings.HasPrefix(got.Text, "### Ownership Rules") || got.Breadcrumb != "What Is Ownership? › Ownership Rules" {
+		t.Fatalf("unexpected first child of the second section: %+v", got)
+	}
+
+	// Reserve leaves room for text added after chunking, also with overlap
+	label := "Chapter 4 › "
+	overlap := chunker.WithOverlap(child, chunker.Overlap{Size: 20, Unit: chunker.OverlapCharacters})
+	for i, c := range chunk(t, chunker.Reserve(overlap, label), OwnershipText) {
+		if n := utf8.RuneCountInString(label + c.Text); n > 80 {
+			t.Fatalf("chunk %d: expected at most 80 characters with the label, got %d", i, n)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package chunker
+
+import (
+	"regexp"
+	"ruborag/internal/tokenizer"
+	"strings"
+)
+
+// Code packs paragraphs like Paragraph, but keeps every fenced code block
+// whole, together with the paragraph before it that introduces the
+// listing. A listing longer than Size characters is split between items:
+// at blank lines and before fn and impl items at the listing's outer
+// indentation, along with their attributes and doc comments; an item that
+// is still too long is split between lines. Each piece of a split listing
+// records the fence it needs to read as code on its own; see
+// Chunk.OpenFence.
+type Code struct {
+	Size    int
+	Counter tokenizer.Counter
+}
+
+// a line that starts a function or impl block, after any visibility and
+// qualifiers: "pub(crate) async fn", "impl<T> Drop for"
+var itemStart = regexp.MustCompile(`^(pub(\([^)]*\))?\s+)?((async|const|unsafe|extern\s+"[^"]*")\s+)*(fn|impl)\b`)
+
+func (c Code) Chunk(doc string) ([]Chunk, error) {
+	limit := budget{c.Size, c.Counter}
+	blocks := paragraphs(doc, span{0, len(doc)})
+
+	// units are packed together, except the pieces of a listing too long
+	// for one chunk, which are chunks of their own so their fences fit
+	var spans, units, code []span
+	flush := func() {
+		spans = append(spans, pack(doc, units, limit, true, nil)...)
+		units = nil
+	}
+	for i := 0; i < len(blocks); i++ {
+		intro, b := span{-1, -1}, blocks[i]
+		switch {
+		case isCodeBlock(doc, b):
+		case i+1 < len(blocks) && isCodeBlock(doc, blocks[i+1]):
+			intro, b = b, blocks[i+1]
+			i++
+		default:
+			units = append(units, pack(doc, []span{b}, limit, true, []splitFunc{sentences})...)
+			continue
+		}
+		code = append(code, b)
+		pieces := listing(doc, intro, b, limit)
+		if len(pieces) == 1 {
+			units = append(units, pieces...)
+			continue
+		}
+		flush()
+		spans = append(spans, pieces...)
+	}
+	flush()
+
+	out := chunks(doc, spans, StrategyCode)
+	fencePieces(doc, out, code)
+	return out, nil
+}
+
+func (c Code) limit() budget {
+	return budget{c.Size, c.Counter}
+}
+
+func (c Code) resized(size int) Chunker {
+	c.Size = size
+	return c
+}
+
+// fencePieces sets the fences of the chunks that hold part of one of the
+// code blocks, both in document order: the opening fence line for pieces
+// that start after it, and the closing fence for pieces that end before
+// the end of the block.
+func fencePieces(doc string, out []Chunk, code []span) {
+	i := 0
+	for _, b := range code {
+		open, closing := fences(doc, b)
+		for i < len(out) && out[i].End <= b.start {
+			i++
+		}
+		for j := i; j < len(out) && out[j].Start < b.end; j++ {
+			if out[j].Start > b.start {
+				out[j].OpenFence = open
+			}
+			if out[j].End < b.end {
+				out[j].CloseFence = closing
+			}
+		}
+	}
+}
+
+// listing returns the units of a code block and the paragraph introducing
+// it (start -1 for none), each within limit.
+func listing(doc string, intro, code span, limit budget) []span {
+	whole := code
+	if intro.start >= 0 {
+		whole.start = intro.start
+	}
+	if limit.fits(doc, whole) {
+		return []span{whole}
+	}
+	// every piece may need both fences again
+	open, closing := fences(doc, code)
+	limit = limit.less(open + "\n\n" + closing)
+
+	// packed on their own, the introduction takes as many of the first
+	// items with it as fit
+	var units []span
+	if intro.start >= 0 {
+		units = pack(doc, []span{intro}, limit, true, []splitFunc{sentences})
+	}
+	units = append(units, pack(doc, codeItems(doc, code), limit, false, []splitFunc{codeLines})...)
+	return pack(doc, units, limit, true, nil)
+}
+
+// fences returns the opening fence line of a code block, info string
+// included, and the fence that closes it.
+func fences(doc string, code span) (open, closing string) {
+	open, _, _ = strings.Cut(doc[code.start:code.end], "\n")
+	open = strings.TrimSpace(open)
+	return open, open[:len(open)-len(strings.TrimLeft(open, open[:1]))]
+}
+
+func isCodeBlock(doc string, s span) bool {
+	return isFence(doc[s.start:s.end])
+}
+
+// codeItems splits a code block at blank lines and before the fn and impl
+// items at its outer indentation, keeping the attributes and comments
+// above an item with it.
+func codeItems(doc string, s span) []span {
+	ls := lines(doc, s)
+	indent := outerIndent(doc, ls)
+	var out []span
+	start := 0
+	for i, l := range ls {
+		line := doc[l.start:l.end]
+		switch {
+		case strings.TrimSpace(line) == "":
+			out = appendLines(out, doc, ls[start:i])
+			start = i + 1
+		case i > start && strings.HasPrefix(line, indent) && itemStart.MatchString(line[len(indent):]):
+			head := i
+			for head > start && isItemPrefix(doc[ls[head-1].start:ls[head-1].end]) {
+				head--
+			}
+			if head > start {
+				out = appendLines(out, doc, ls[start:head])
+				start = head
+			}
+		}
+	}
+	return appendLines(out, doc, ls[start:])
+}
+
+// outerIndent returns the indentation shared by the code lines of a
+// block, which is empty unless the whole listing is indented.
+func outerIndent(doc string, ls []span) string {
+	indent := ""
+	found := false
+	for _, l := range ls {
+		line := doc[l.start:l.end]
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" || isFence(trimmed) {
+			continue
+		}
+		lead := line[:len(line)-len(trimmed)]
+		if !found || len(lead) < len(indent) {
+			indent, found = lead, true
+		}
+	}
+	return indent
+}
+
+// isItemPrefix reports whether line belongs to the item below it: an
+// attribute, a comment or the opening fence.
+func isItemPrefix(line string) bool {
+	line = strings.TrimSpace(line)
+	return strings.HasPrefix(line, "#[") || strings.HasPrefix(line, "//") || isFence(line)
+}
+
+// codeLines splits s into its non-blank lines, indentation included.
+func codeLines(doc string, s span) []span {
+	var out []span
+	for _, l := range lines(doc, s) {
+		out = appendLines(out, doc, []span{l})
+	}
+	return out
+}
+
+// appendLines appends the span from the first to the last non-blank line
+// of ls, keeping the indentation of the first.
+func appendLines(spans []span, doc string, ls []span) []span {
+	for len(ls) > 0 && strings.TrimSpace(doc[ls[0].start:ls[0].end]) == "" {
+		ls = ls[1:]
+	}
+	for len(ls) > 0 && strings.TrimSpace(doc[ls[len(ls)-1].start:ls[len(ls)-1].end]) == "" {
+		ls = ls[:len(ls)-1]
+	}
+	if len(ls) == 0 {
+		return spans
+	}
+	s := span{ls[0].start, ls[len(ls)-1].end}
+	if t, ok := trim(doc, s); ok {
+		s.end = t.end
+	}
+	return append(spans, s)
+}
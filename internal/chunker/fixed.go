@@ -0,0 +1,71 @@
+package chunker
+
+import (
+	"ruborag/internal/tokenizer"
+	"unicode/utf8"
+)
+
+// Fixed cuts the document every Size characters. Chunks cover the whole
+// document, whitespace included, and may end mid-word; cuts holding only
+// whitespace are dropped.
+type Fixed struct {
+	Size int
+	// Counter, when set, measures Size in tokens instead; chunks are then
+	// the longest runs of text that count at most Size tokens. The other
+	// strategies use it the same way.
+	Counter tokenizer.Counter
+}
+
+func (c Fixed) Chunk(doc string) ([]Chunk, error) {
+	var spans []span
+	for _, s := range fixedSpans(doc, span{0, len(doc)}, budget{c.Size, c.Counter}) {
+		if _, ok := trim(doc, s); ok {
+			spans = append(spans, s)
+		}
+	}
+	return chunks(doc, spans, StrategyFixed), nil
+}
+
+func (c Fixed) limit() budget {
+	return budget{c.Size, c.Counter}
+}
+
+func (c Fixed) resized(size int) Chunker {
+	c.Size = size
+	return c
+}
+
+// fixedSpans cuts s into spans that fill b; the last may be shorter.
+func fixedSpans(doc string, s span, b budget) []span {
+	if b.counter != nil {
+		return countedSpans(doc, s, b)
+	}
+	var out []span
+	start, n := s.start, 0
+	for i := range doc[s.start:s.end] {
+		if n == b.size {
+			out = append(out, span{start, s.start + i})
+			start, n = s.start+i, 0
+		}
+		n++
+	}
+	if start < s.end {
+		out = append(out, span{start, s.end})
+	}
+	return out
+}
+
+// countedSpans cuts s into the longest spans that count at most b.size
+// tokens, taking at least one rune each.
+func countedSpans(doc string, s span, b budget) []span {
+	var out []span
+	for start := s.start; start < s.end; {
+		n := len(tokenizer.Truncate(b.counter, doc[start:s.end], b.size))
+		if n == 0 {
+			_, n = utf8.DecodeRuneInString(doc[start:s.end])
+		}
+		out = append(out, span{start, start + n})
+		start += n
+	}
+	return out
+}
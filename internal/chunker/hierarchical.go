@@ -0,0 +1,92 @@
+package chunker
+
+import (
+	"ruborag/internal/tokenizer"
+	"strings"
+)
+
+// BreadcrumbSeparator joins the headings of a breadcrumb.
+const BreadcrumbSeparator = " › "
+
+// Hierarchical splits like Section, one chunk per section with long
+// sections packed by paragraph, and gives every chunk the breadcrumb of
+// the headings it falls under, e.g. "What Is Ownership? › Variable Scope",
+// so a paragraph about moves is embedded together with where it comes from.
+// Size leaves room for the breadcrumb.
+type Hierarchical struct {
+	Size    int
+	Counter tokenizer.Counter
+}
+
+func (c Hierarchical) Chunk(doc string) ([]Chunk, error) {
+	headings := headingsOf(doc)
+	limit := budget{c.Size, c.Counter}
+
+	var out []Chunk
+	var path []heading
+	next := 0
+	for _, s := range sections(doc, span{0, len(doc)}) {
+		for next < len(headings) && headings[next].start <= s.start {
+			h := headings[next]
+			for len(path) > 0 && path[len(path)-1].level >= h.level {
+				path = path[:len(path)-1]
+			}
+			path = append(path, h)
+			next++
+		}
+		titles := make([]string, len(path))
+		for j, h := range path {
+			titles[j] = h.title
+		}
+		crumb := strings.Join(titles, BreadcrumbSeparator)
+
+		b := limit
+		if crumb != "" {
+			b = limit.less(crumb + "\n\n")
+		}
+		pieces := chunks(doc, pack(doc, []span{s}, b, false, []splitFunc{paragraphs, sentences}), StrategyHierarchical)
+		for i := range pieces {
+			pieces[i].Breadcrumb = crumb
+		}
+		out = append(out, pieces...)
+	}
+	return out, nil
+}
+
+func (c Hierarchical) limit() budget {
+	return budget{c.Size, c.Counter}
+}
+
+func (c Hierarchical) resized(size int) Chunker {
+	c.Size = size
+	return c
+}
+
+type heading struct {
+	start int
+	level int
+	title string
+}
+
+// headingsOf returns the ATX headings of doc outside fenced code blocks.
+func headingsOf(doc string) []heading {
+	var out []heading
+	fence := ""
+	for _, l := range lines(doc, span{0, len(doc)}) {
+		line := strings.TrimSpace(doc[l.start:l.end])
+		switch {
+		case fence != "":
+			if strings.HasPrefix(line, fence) {
+				fence = ""
+			}
+		case isFence(line):
+			fence = line[:3]
+		default:
+			if level := headingLevel(line); level > 0 {
+				title := strings.TrimSpace(strings.TrimRight(line[level:], "#"))
+				out = append(out, heading{start: l.start, level: level, title: title})
+			}
+		}
+	}
+	return out
+}
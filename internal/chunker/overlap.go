@@ -0,0 +1,174 @@
+package chunker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// OverlapUnit is what an Overlap is counted in.
+type OverlapUnit string
+
+const (
+	OverlapCharacters OverlapUnit = "characters"
+	OverlapSentences  OverlapUnit = "sentences"
+)
+
+// Overlap is how much of the end of each chunk the next one repeats, so a
+// sentence cut by a chunk boundary is whole in at least one chunk.
+type Overlap struct {
+	Size int
+	Unit OverlapUnit
+}
+
+// ParseOverlap reads an overlap given on the command line: "200" is 200
+// characters and "2s" is two sentences. "" and "0" mean no overlap.
+func ParseOverlap(s string) (Overlap, error) {
+	unit := OverlapCharacters
+	digits := s
+	if strings.HasSuffix(s, "s") {
+		unit, digits = OverlapSentences, strings.TrimSuffix(s, "s")
+	}
+	if s == "" {
+		return Overlap{}, nil
+	}
+	n, err := strconv.Atoi(digits)
+	if err != nil || n < 0 {
+		return Overlap{}, fmt.Errorf("invalid chunk overlap %q (want a number of characters, or of sentences like 2s)", s)
+	}
+	if n == 0 {
+		return Overlap{}, nil
+	}
+	return Overlap{Size: n, Unit: unit}, nil
+}
+
+func (o Overlap) String() string {
+	if o.Unit == OverlapSentences {
+		return fmt.Sprintf("%d sentences", o.Size)
+	}
+	return fmt.Sprintf("%d characters", o.Size)
+}
+
+// WithOverlap wraps c so every chunk after the first also starts with the
+// end of the chunk before it: up to o.Size characters, moved forward to
+// the start of a word, or the last o.Size sentences. The overlap counts
+// toward the size c keeps to: the document is chunked with room for the
+// longest overlap, up to half the size, and an overlap that still does
+// not fit is cut short at a word. A zero Overlap returns c unchanged.
+func WithOverlap(c Chunker, o Overlap) Chunker {
+	if o.Size <= 0 {
+		return c
+	}
+	return overlapping{c, o}
+}
+
+type overlapping struct {
+	Chunker
+	overlap Overlap
+}
+
+func (c overlapping) Chunk(doc string) ([]Chunk, error) {
+	chunks, err := c.Chunker.Chunk(doc)
+	if err != nil {
+		return nil, err
+	}
+	inner, limited := c.Chunker.(sized)
+	// rechunking with the semantic chunker would embed doc again
+	if _, semantic := c.Chunker.(*Semantic); limited && !semantic && len(chunks) > 1 {
+		limit, reserve := inner.limit(), 0
+		for i := 1; i < len(chunks); i++ {
+			start := c.overlapStart(doc, span{chunks[i-1].Start, chunks[i].Start})
+			reserve = max(reserve, limit.length(doc, span{start, chunks[i].Start}))
+		}
+		// a long overlap is cut short rather than shrink every chunk to it
+		reserve = min(reserve, limit.size/2)
+		if reserve > 0 {
+			if chunks, err = inner.resized(max(1, limit.size-reserve)).Chunk(doc); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for i := len(chunks) - 1; i > 0; i-- {
+		prev, cur := chunks[i-1], chunks[i]
+		start := c.overlapStart(doc, span{prev.Start, cur.Start})
+		for start < cur.Start {
+			o := cur
+			// a piece of a listing that now repeats the opening fence has it
+			if o.OpenFence != "" && strings.Contains(doc[start:cur.Start], o.OpenFence) {
+				o.OpenFence = ""
+			}
+			o.Start = start
+			o.Text = doc[o.Start:o.End]
+			o.Overlap = max(0, prev.End-o.Start)
+			if !limited || inner.limit().measure(o.Embedded()) <= inner.limit().size {
+				chunks[i] = o
+				break
+			}
+			start = nextWord(doc, span{start, cur.Start})
+		}
+	}
+	return chunks, nil
+}
+
+// nextWord returns the start of the word after the one s starts with, or
+// s.end if there is none.
+func nextWord(doc string, s span) int {
+	i := strings.IndexFunc(doc[s.start:s.end], unicode.IsSpace)
+	if i < 0 {
+		return s.end
+	}
+	if t, ok := trim(doc, span{s.start + i, s.end}); ok {
+		return t.start
+	}
+	return s.end
+}
+
+// overlapStart returns where a chunk starting at s.end begins once it
+// repeats the end of s, which spans the previous chunk and the gap after
+// it.
+func (c overlapping) overlapStart(doc string, s span) int {
+	if c.overlap.Unit == OverlapSentences {
+		units := sentences(doc, s)
+		if len(units) == 0 {
+			return s.end
+		}
+		return units[max(0, len(units)-c.overlap.Size)].start
+	}
+
+	start := s.end
+	for n := 0; n < c.overlap.Size && start > s.start; n++ {
+		_, size := utf8.DecodeLastRuneInString(doc[s.start:start])
+		start -= size
+	}
+	// do not start mid-word unless the window is a single word
+	if r, _ := utf8.DecodeLastRuneInString(doc[:start]); start > s.start && !unicode.IsSpace(r) {
+		if i := strings.IndexFunc(doc[start:s.end], unicode.IsSpace); i >= 0 {
+			start += i
+		}
+	}
+	if t, ok := trim(doc, span{start, s.end}); ok {
+		return t.start
+	}
+	return s.end
+}
+
+// Join reassembles consecutive chunks of one document into a contiguous
+// passage, dropping the text each chunk repeats from the one before. The
+// repeated text of an overlapping chunk runs up to the end of the previous
+// chunk, so the whitespace between them is kept; chunks without overlap
+// are concatenated as they are. Join only needs Text and Overlap, so it
+// works on chunks loaded from the index.
+func Join(chunks []Chunk) string {
+	var sb strings.Builder
+	for i, c := range chunks {
+		text := c.Text
+		if i > 0 {
+			text = text[min(c.Overlap, len(text)):]
+		}
+		sb.WriteString(text)
+	}
+	return sb.String()
+}
@@ -0,0 +1,73 @@
+package chunker
+
+import (
+	"ruborag/internal/tokenizer"
+	"strings"
+)
+
+// Paragraph packs whole paragraphs into chunks of up to Size characters.
+// Paragraphs are separated by blank lines, or lines holding only ">"
+// inside a block quote; a fenced code block is one paragraph even when it
+// contains blank lines. Text output of ruborag parse has no blank lines,
+// so it is packed by sentence.
+type Paragraph struct {
+	Size    int
+	Counter tokenizer.Counter
+}
+
+func (c Paragraph) Chunk(doc string) ([]Chunk, error) {
+	units := paragraphs(doc, span{0, len(doc)})
+	return chunks(doc, pack(doc, units, budget{c.Size, c.Counter}, true, []splitFunc{sentences}), StrategyParagraph), nil
+}
+
+func (c Paragraph) limit() budget {
+	return budget{c.Size, c.Counter}
+}
+
+func (c Paragraph) resized(size int) Chunker {
+	c.Size = size
+	return c
+}
+
+// paragraphs splits s at blank lines outside fenced code blocks.
+func paragraphs(doc string, s span) []span {
+	var out []span
+	start := s.start
+	fence := ""
+	for _, l := range lines(doc, s) {
+		line := strings.TrimSpace(doc[l.start:l.end])
+		switch {
+		case fence != "":
+			if strings.HasPrefix(line, fence) {
+				fence = ""
+			}
+		case isFence(line):
+			fence = line[:3]
+		case strings.Trim(line, "> ") == "":
+			out = appendTrimmed(out, doc, span{start, l.start})
+			start = l.end
+		}
+	}
+	return appendTrimmed(out, doc, span{start, s.end})
+}
+
+// isFence reports whether line opens a fenced code block.
+func isFence(line string) bool {
+	return strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~")
+}
+
+// lines returns the lines of s, each without its line break.
+func lines(doc string, s span) []span {
+	var out []span
+	start := s.start
+	for start <= s.end {
+		i := strings.IndexByte(doc[start:s.end], '\n')
+		if i < 0 {
+			out = append(out, span{start, s.end})
+			break
+		}
+		out = append(out, span{start, start + i})
+		start += i + 1
+	}
+	return out
+}
@@ -0,0 +1,40 @@
+package chunker
+
+// Family is a parent chunk and the smaller chunks it is split into.
+type Family struct {
+	Parent   Chunk
+	Children []Chunk
+}
+
+// Nest splits doc into parent chunks, such as whole sections, and every
+// parent into the chunks of child, for small-to-big retrieval: the
+// children are embedded and matched, the parent is what a match returns.
+// Child offsets are in doc, and children without a breadcrumb take their
+// parent's, which child leaves room for.
+func Nest(doc string, parent, child Chunker) ([]Family, error) {
+	parents, err := parent.Chunk(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	families := make([]Family, len(parents))
+	for i, p := range parents {
+		c := child
+		if p.Breadcrumb != "" {
+			c = Reserve(child, p.Breadcrumb+"\n\n")
+		}
+		children, err := c.Chunk(p.Text)
+		if err != nil {
+			return nil, err
+		}
+		for j := range children {
+			children[j].Start += p.Start
+			children[j].End += p.Start
+			if children[j].Breadcrumb == "" {
+				children[j].Breadcrumb = p.Breadcrumb
+			}
+		}
+		families[i] = Family{Parent: p, Children: children}
+	}
+	return families, nil
+}
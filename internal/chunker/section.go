@@ -0,0 +1,64 @@
+package chunker
+
+import (
+	"ruborag/internal/tokenizer"
+	"strings"
+)
+
+// Section makes one chunk per Markdown section: a heading and the text up
+// to the next heading of any level. Text before the first heading is a
+// section of its own. Sections longer than Size characters are packed by
+// paragraph. Headings inside fenced code blocks, such as Rust attributes,
+// are not section breaks.
+type Section struct {
+	Size    int
+	Counter tokenizer.Counter
+}
+
+func (c Section) Chunk(doc string) ([]Chunk, error) {
+	units := sections(doc, span{0, len(doc)})
+	return chunks(doc, pack(doc, units, budget{c.Size, c.Counter}, false, []splitFunc{paragraphs, sentences}), StrategySection), nil
+}
+
+func (c Section) limit() budget {
+	return budget{c.Size, c.Counter}
+}
+
+func (c Section) resized(size int) Chunker {
+	c.Size = size
+	return c
+}
+
+// sections splits s before every ATX heading outside fenced code blocks.
+func sections(doc string, s span) []span {
+	var out []span
+	start := s.start
+	fence := ""
+	for _, l := range lines(doc, s) {
+		line := strings.TrimSpace(doc[l.start:l.end])
+		switch {
+		case fence != "":
+			if strings.HasPrefix(line, fence) {
+				fence = ""
+			}
+		case isFence(line):
+			fence = line[:3]
+		case headingLevel(line) > 0:
+			out = appendTrimmed(out, doc, span{start, l.start})
+			start = l.start
+		}
+	}
+	return appendTrimmed(out, doc, span{start, s.end})
+}
+
+// headingLevel returns the level of an ATX heading line ("## Title"), or 0.
+func headingLevel(line string) int {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 || (level < len(line) && line[level] != ' ') {
+		return 0
+	}
+	return level
+}
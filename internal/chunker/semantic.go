@@ -0,0 +1,159 @@
+package chunker
+
+import (
+	"errors"
+	"ruborag/internal/similarity"
+	"ruborag/internal/tokenizer"
+	"slices"
+)
+
+// Semantic splits where the topic shifts. Every sentence is embedded, and
+// at each boundary between two sentences the mean vector of the Window
+// sentences before it is compared with that of the Window sentences after
+// it. A boundary whose similarity falls below the Percentile-th percentile
+// of all the similarities in the document ends a chunk, unless the chunk
+// is still shorter than MinSize; a chunk also ends before a sentence that
+// would take it past MaxSize. Sizes are in characters, or in tokens with
+// Counter.
+//
+// Chunk embeds the whole document sentence by sentence, so it costs an
+// embedding call per batch of sentences, and returns the error of Embed
+// when it fails.
+type Semantic struct {
+	// Embed returns one vector per text, in order.
+	Embed      func(texts []string) ([][]float32, error)
+	Window     int
+	Percentile float64
+	MinSize    int
+	MaxSize    int
+	Counter    tokenizer.Counter
+}
+
+// Breakpoint is a boundary between two sentences considered by Semantic.
+type Breakpoint struct {
+	// Offset is where the sentence after the boundary starts.
+	Offset int
+	// Similarity is the cosine similarity of the windows on either side.
+	Similarity float32
+	// Shift is whether Similarity is below the threshold.
+	Shift bool
+	// Split is whether a chunk ends here: at a shift, unless the chunk
+	// would be shorter than MinSize, or to keep the next one within
+	// MaxSize.
+	Split bool
+}
+
+func (c *Semantic) Chunk(doc string) ([]Chunk, error) {
+	breaks, _, err := c.Breakpoints(doc)
+	if err != nil {
+		return nil, err
+	}
+	units := sentences(doc, span{0, len(doc)})
+	if len(units) == 0 {
+		return nil, nil
+	}
+
+	var spans []span
+	start := units[0].start
+	for i, b := range breaks {
+		if b.Split {
+			spans = append(spans, span{start, units[i].end})
+			start = b.Offset
+		}
+	}
+	spans = append(spans, span{start, units[len(units)-1].end})
+	// a single sentence longer than MaxSize is cut
+	return chunks(doc, pack(doc, spans, budget{c.MaxSize, c.Counter}, false, nil), StrategySemantic), nil
+}
+
+func (c *Semantic) limit() budget {
+	return budget{c.MaxSize, c.Counter}
+}
+
+func (c *Semantic) resized(size int) Chunker {
+	r := *c
+	r.MaxSize, r.MinSize = size, min(c.MinSize, size)
+	return &r
+}
+
+// Breakpoints embeds the sentences of doc and returns every boundary
+// between them, in order, along with the similarity threshold for a
+// topic shift.
+func (c *Semantic) Breakpoints(doc string) ([]Breakpoint, float32, error) {
+	if c.Embed == nil {
+		return nil, 0, errors.New("semantic chunker has no embedding function")
+	}
+	if c.MaxSize <= 0 {
+		return nil, 0, errors.New("semantic chunker needs a positive maximum size")
+	}
+	units := sentences(doc, span{0, len(doc)})
+	if len(units) < 2 {
+		return nil, 0, nil
+	}
+	texts := make([]string, len(units))
+	for i, u := range units {
+		texts[i] = doc[u.start:u.end]
+	}
+	vecs, err := c.Embed(texts)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(vecs) != len(units) {
+		return nil, 0, errors.New("semantic chunker got a different number of embeddings than sentences")
+	}
+
+	window := max(c.Window, 1)
+	sims := make([]float32, len(units)-1)
+	for i := range sims {
+		before := mean(vecs[max(0, i+1-window) : i+1])
+		after := mean(vecs[i+1 : min(len(vecs), i+1+window)])
+		sims[i] = similarity.CosineSimilarity(before, after)
+	}
+	threshold := percentile(sims, c.Percentile)
+
+	limit := budget{c.MaxSize, c.Counter}
+	breaks := make([]Breakpoint, len(sims))
+	start := units[0].start
+	for i, sim := range sims {
+		b := Breakpoint{Offset: units[i+1].start, Similarity: sim, Shift: sim < threshold}
+		switch {
+		case !limit.fits(doc, span{start, units[i+1].end}):
+			b.Split = true
+		case b.Shift:
+			b.Split = limit.length(doc, span{start, units[i].end}) >= c.MinSize
+		}
+		if b.Split {
+			start = b.Offset
+		}
+		breaks[i] = b
+	}
+	return breaks, threshold, nil
+}
+
+// mean returns the element-wise mean of vecs.
+func mean(vecs [][]float32) []float32 {
+	out := make([]float32, len(vecs[0]))
+	for _, v := range vecs {
+		for i := range min(len(v), len(out)) {
+			out[i] += v[i]
+		}
+	}
+	for i := range out {
+		out[i] /= float32(len(vecs))
+	}
+	return out
+}
+
+// percentile returns the p-th percentile (0-100) of values, interpolating
+// between the closest ranks.
+func percentile(values []float32, p float64) float32 {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	rank := min(max(p, 0), 100) / 100 * float64(len(sorted)-1)
+	lo := int(rank)
+	if lo+1 >= len(sorted) {
+		return sorted[lo]
+	}
+	frac := float32(rank - float64(lo))
+	return sorted[lo] + frac*(sorted[lo+1]-sorted[lo])
+}
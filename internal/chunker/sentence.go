@@ -0,0 +1,84 @@
+package chunker
+
+import (
+	"ruborag/internal/tokenizer"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Sentence packs whole sentences into chunks of up to Size characters.
+// A sentence ends at ".", "!" or "?" followed by whitespace, after any
+// closing quotes or brackets, and at every line break, so table rows and
+// list items are units of their own.
+type Sentence struct {
+	Size    int
+	Counter tokenizer.Counter
+}
+
+func (c Sentence) Chunk(doc string) ([]Chunk, error) {
+	units := sentences(doc, span{0, len(doc)})
+	return chunks(doc, pack(doc, units, budget{c.Size, c.Counter}, true, nil), StrategySentence), nil
+}
+
+func (c Sentence) limit() budget {
+	return budget{c.Size, c.Counter}
+}
+
+func (c Sentence) resized(size int) Chunker {
+	c.Size = size
+	return c
+}
+
+// abbreviations whose period does not end a sentence
+var abbreviations = []string{"e.g.", "i.e.", "vs.", "cf."}
+
+// sentences splits s into sentences.
+func sentences(doc string, s span) []span {
+	var out []span
+	start := s.start
+	text := doc[s.start:s.end]
+	for i := 0; i < len(text); {
+		r, n := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case r == '\n':
+			out = appendTrimmed(out, doc, span{start, s.start + i})
+			start = s.start + i + n
+		case r == '.' || r == '!' || r == '?':
+			end := i + n
+			for end < len(text) {
+				c, m := utf8.DecodeRuneInString(text[end:])
+				if !strings.ContainsRune(`)]"'’”`, c) {
+					break
+				}
+				end += m
+			}
+			next, _ := utf8.DecodeRuneInString(text[end:])
+			if end < len(text) && !unicode.IsSpace(next) {
+				break
+			}
+			if r == '.' && isAbbreviation(text[:i+n]) {
+				break
+			}
+			out = appendTrimmed(out, doc, span{start, s.start + end})
+			start = s.start + end
+			i = end
+			continue
+		}
+		i += n
+	}
+	return appendTrimmed(out, doc, span{start, s.end})
+}
+
+func isAbbreviation(before string) bool {
+	lower := strings.ToLower(before)
+	for _, a := range abbreviations {
+		if strings.HasSuffix(lower, a) {
+			rest := lower[:len(lower)-len(a)]
+			if r, _ := utf8.DecodeLastRuneInString(rest); rest == "" || !unicode.IsLetter(r) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -59,32 +59,98 @@ func (db *DB) Close() error {
 
 func (db *DB) initSchema() error {
 	const schema = `
+	CREATE TABLE IF NOT EXISTS parents (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		source_file TEXT NOT NULL,
+		parent_index INTEGER NOT NULL,
+		content TEXT NOT NULL,
+		breadcrumb TEXT NOT NULL DEFAULT '',
+		UNIQUE (source_file, parent_index)
+	);
+
 	CREATE TABLE IF NOT EXISTS embeddings (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		source_file TEXT NOT NULL,
 		chunk_index INTEGER NOT NULL,
 		content TEXT NOT NULL,
-		embedding BLOB NOT NULL
+		embedding BLOB NOT NULL,
+		overlap INTEGER NOT NULL DEFAULT 0,
+		breadcrumb TEXT NOT NULL DEFAULT '',
+		parent_id INTEGER REFERENCES parents (id),
+		start_offset INTEGER NOT NULL DEFAULT 0,
+		end_offset INTEGER NOT NULL DEFAULT 0
 	);
+
+	CREATE TABLE IF NOT EXISTS links (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		source_file TEXT NOT NULL,
+		source_anchor TEXT NOT NULL,
+		target_file TEXT NOT NULL,
+		target_anchor TEXT NOT NULL,
+		text TEXT NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS links_source ON links (source_file);
+	CREATE INDEX IF NOT EXISTS links_target ON links (target_file);
 	`
 	if _, err := db.conn.Exec(schema); err != nil {
 		return fmt.Errorf("init schema: %w", err)
 	}
+	return db.migrate()
+}
+
+// columns added to the embeddings table after its first release, with
+// their definitions, added to databases created before them
+var embeddingColumns = []struct{ name, definition string }{
+	{"overlap", "INTEGER NOT NULL DEFAULT 0"},
+	{"breadcrumb", "TEXT NOT NULL DEFAULT ''"},
+	{"parent_id", "INTEGER REFERENCES parents (id)"},
+	{"start_offset", "INTEGER NOT NULL DEFAULT 0"},
+	{"end_offset", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// migrate adds the columns missing from an existing embeddings table.
+func (db *DB) migrate() error {
+	rows, err := db.conn.Query(`PRAGMA table_info(embeddings);`)
+	if err != nil {
+		return fmt.Errorf("read embeddings columns: %w", err)
+	}
+	existing := map[string]bool{}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, typ string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan embeddings column: %w", err)
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range embeddingColumns {
+		if existing[c.name] {
+			continue
+		}
+		if _, err := db.conn.Exec(`ALTER TABLE embeddings ADD COLUMN ` + c.name + ` ` + c.definition + `;`); err != nil {
+			return fmt.Errorf("add column %s: %w", c.name, err)
+		}
+	}
 	return nil
 }
 
-func (db *DB) InsertEmbedding(
-	sourceFile string,
-	chunkIndex int,
-	content string,
-	embedding []float32,
-) error {
-	if len(embedding) == 0 {
+// InsertEmbedding stores the embedding of one chunk. ParentID, if not 0,
+// must be a parent passage stored with InsertParent.
+func (db *DB) InsertEmbedding(e Embedding) error {
+	if len(e.Vector) == 0 {
 		return fmt.Errorf("embedding cannot be empty")
 	}
 
 	buf := new(bytes.Buffer)
-	if err := binary.Write(buf, binary.LittleEndian, embedding); err != nil {
+	if err := binary.Write(buf, binary.LittleEndian, e.Vector); err != nil {
 		return fmt.Errorf("encode embedding: %w", err)
 	}
 
@@ -93,15 +159,30 @@ func (db *DB) InsertEmbedding(
 		source_file,
 		chunk_index,
 		content,
+		start_offset,
+		end_offset,
+		breadcrumb,
+		overlap,
+		parent_id,
 		embedding
-	) VALUES (?, ?, ?, ?);
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);
 	`
 
+	var parent sql.NullInt64
+	if e.ParentID != 0 {
+		parent = sql.NullInt64{Int64: e.ParentID, Valid: true}
+	}
+
 	_, err := db.conn.Exec(
 		query,
-		sourceFile,
-		chunkIndex,
-		content,
+		e.SourceFile,
+		e.ChunkIndex,
+		e.Content,
+		e.Start,
+		e.End,
+		e.Breadcrumb,
+		e.Overlap,
+		parent,
 		buf.Bytes(),
 	)
 	if err != nil {
@@ -130,15 +211,30 @@ func (db *DB) EmbeddingExists(sourceFile string, chunkIndex int) (bool, error) {
 	return true, nil
 }
 
-type StoredEmbedding struct {
+// Embedding is a chunk of a source file and its vector, as InsertEmbedding
+// stores it and GetAllEmbeddings returns it.
+type Embedding struct {
 	SourceFile string
 	ChunkIndex int
-	Vector     []float32
+	// Content is the raw chunk text, without the breadcrumb.
+	Content string
+	// Start and End are the byte offsets of Content in the source file;
+	// both are 0 for chunks embedded before offsets were recorded.
+	Start int
+	End   int
+	// Breadcrumb is the heading path embedded together with Content.
+	Breadcrumb string
+	// Overlap is the number of bytes at the start of Content repeated
+	// from the previous chunk of the same file.
+	Overlap int
+	// ParentID is the parent passage Content was cut from, or 0.
+	ParentID int64
+	Vector   []float32
 }
 
-func (db *DB) GetAllEmbeddings() ([]StoredEmbedding, error) {
+func (db *DB) GetAllEmbeddings() ([]Embedding, error) {
 	const query = `
-	SELECT source_file, chunk_index, embedding
+	SELECT source_file, chunk_index, content, start_offset, end_offset, breadcrumb, overlap, parent_id, embedding
 	FROM embeddings;
 	`
 
@@ -148,14 +244,19 @@ func (db *DB) GetAllEmbeddings() ([]StoredEmbedding, error) {
 	}
 	defer rows.Close()
 
-	var results []StoredEmbedding
+	var results []Embedding
 
 	for rows.Next() {
 		var sourceFile string
 		var chunkIndex int
+		var content string
+		var start, end int
+		var breadcrumb string
+		var overlap int
+		var parentID sql.NullInt64
 		var blob []byte
 
-		if err := rows.Scan(&sourceFile, &chunkIndex, &blob); err != nil {
+		if err := rows.Scan(&sourceFile, &chunkIndex, &content, &start, &end, &breadcrumb, &overlap, &parentID, &blob); err != nil {
 			return nil, fmt.Errorf("scan row: %w", err)
 		}
 
@@ -164,9 +265,15 @@ func (db *DB) GetAllEmbeddings() ([]StoredEmbedding, error) {
 			return nil, fmt.Errorf("decode embedding: %w", err)
 		}
 
-		results = append(results, StoredEmbedding{
+		results = append(results, Embedding{
 			SourceFile: sourceFile,
 			ChunkIndex: chunkIndex,
+			Content:    content,
+			Start:      start,
+			End:        end,
+			Breadcrumb: breadcrumb,
+			Overlap:    overlap,
+			ParentID:   parentID.Int64,
 			Vector:     vec,
 		})
 	}
@@ -178,6 +285,56 @@ func (db *DB) GetAllEmbeddings() ([]StoredEmbedding, error) {
 	return results, nil
 }
 
+// StoredParent is a passage, such as a whole section, that is not embedded
+// itself: its smaller chunks are, and search shows it in their place.
+type StoredParent struct {
+	ID          int64
+	SourceFile  string
+	ParentIndex int
+	Content     string
+	Breadcrumb  string
+}
+
+// InsertParent stores the parent passage parentIndex of sourceFile,
+// replacing the content of one stored by an earlier run, and returns its
+// id for InsertEmbedding.
+func (db *DB) InsertParent(sourceFile string, parentIndex int, content, breadcrumb string) (int64, error) {
+	const query = `
+	INSERT INTO parents (
+		source_file,
+		parent_index,
+		content,
+		breadcrumb
+	) VALUES (?, ?, ?, ?)
+	ON CONFLICT (source_file, parent_index) DO UPDATE SET
+		content = excluded.content,
+		breadcrumb = excluded.breadcrumb
+	RETURNING id;
+	`
+
+	var id int64
+	if err := db.conn.QueryRow(query, sourceFile, parentIndex, content, breadcrumb).Scan(&id); err != nil {
+		return 0, fmt.Errorf("insert parent: %w", err)
+	}
+	return id, nil
+}
+
+// GetParent returns the parent passage with the given id.
+func (db *DB) GetParent(id int64) (StoredParent, error) {
+	const query = `
+	SELECT id, source_file, parent_index, content, breadcrumb
+	FROM parents
+	WHERE id = ?;
+	`
+
+	var p StoredParent
+	err := db.conn.QueryRow(query, id).Scan(&p.ID, &p.SourceFile, &p.ParentIndex, &p.Content, &p.Breadcrumb)
+	if err != nil {
+		return StoredParent{}, fmt.Errorf("get parent %d: %w", id, err)
+	}
+	return p, nil
+}
+
 func DecodeEmbedding(blob []byte) ([]float32, error) {
 	if len(blob)%4 != 0 {
 		return nil, fmt.Errorf("invalid embedding blob size")
@@ -192,3 +349,88 @@ func DecodeEmbedding(blob []byte) ([]float32, error) {
 
 	return vec, nil
 }
+
+// StoredLink is one edge of the cross-reference graph: a link from a
+// section of one page to a page and optional anchor.
+type StoredLink struct {
+	SourceFile   string
+	SourceAnchor string
+	TargetFile   string
+	TargetAnchor string
+	Text         string
+}
+
+// ReplaceLinks stores the outbound links of sourceFile, replacing the ones
+// recorded by an earlier run.
+func (db *DB) ReplaceLinks(sourceFile string, links []StoredLink) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("begin links: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM links WHERE source_file = ?;`, sourceFile); err != nil {
+		return fmt.Errorf("delete links: %w", err)
+	}
+
+	const query = `
+	INSERT INTO links (
+		source_file,
+		source_anchor,
+		target_file,
+		target_anchor,
+		text
+	) VALUES (?, ?, ?, ?, ?);
+	`
+	for _, l := range links {
+		if _, err := tx.Exec(query, sourceFile, l.SourceAnchor, l.TargetFile, l.TargetAnchor, l.Text); err != nil {
+			return fmt.Errorf("insert link: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit links: %w", err)
+	}
+	return nil
+}
+
+// LinksFrom returns the links recorded on file, in document order.
+func (db *DB) LinksFrom(file string) ([]StoredLink, error) {
+	return db.queryLinks(`
+	SELECT source_file, source_anchor, target_file, target_anchor, text
+	FROM links
+	WHERE source_file = ?
+	ORDER BY id;
+	`, file)
+}
+
+// LinksTo returns the links pointing at file, grouped by linking page.
+func (db *DB) LinksTo(file string) ([]StoredLink, error) {
+	return db.queryLinks(`
+	SELECT source_file, source_anchor, target_file, target_anchor, text
+	FROM links
+	WHERE target_file = ?
+	ORDER BY source_file, id;
+	`, file)
+}
+
+func (db *DB) queryLinks(query string, args ...any) ([]StoredLink, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query links: %w", err)
+	}
+	defer rows.Close()
+
+	var links []StoredLink
+	for rows.Next() {
+		var l StoredLink
+		if err := rows.Scan(&l.SourceFile, &l.SourceAnchor, &l.TargetFile, &l.TargetAnchor, &l.Text); err != nil {
+			return nil, fmt.Errorf("scan link: %w", err)
+		}
+		links = append(links, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return links, nil
+}
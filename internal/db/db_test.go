@@ -1,6 +1,7 @@
 package db_test
 
 import (
+	"database/sql"
 	"os"
 	"path/filepath"
 	"ruborag/internal/db"
@@ -49,14 +50,13 @@ func TestInsertEmbedding(t *testing.T) {
 	}
 	defer database.Close()
 
-	embedding := []float32{0.1, 0.2, 0.3}
-
-	err = database.InsertEmbedding(
-		"example-parsed.txt",
-		0,
-		"Ownership is Rust’s most unique feature.",
-		embedding,
-	)
+	err = database.InsertEmbedding(db.Embedding{
+		SourceFile: "example-parsed.txt",
+		ChunkIndex: 0,
+		Content:    "Ownership is Rust’s most unique feature.",
+		End:        40,
+		Vector:     []float32{0.1, 0.2, 0.3},
+	})
 	if err != nil {
 		t.Fatalf("insert embedding: %v", err)
 	}
@@ -74,3 +74,165 @@ func TestInsertEmbedding(t *testing.T) {
 		t.Fatalf("expected 1 row, got %d", count)
 	}
 }
+
+func TestReplaceLinks(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, db.DefaultDBName)
+
+	database, err := db.Open(dbPath)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+
+	source := "ch04-02-references-and-borrowing.html"
+	stale := []db.StoredLink{{TargetFile: "ch99-00-gone.html"}}
+	if err := database.ReplaceLinks(source, stale); err != nil {
+		t.Fatalf("insert links: %v", err)
+	}
+
+	links := []db.StoredLink{
+		{SourceAnchor: "references-and-borrowing", TargetFile: "ch04-01-what-is-ownership.html", TargetAnchor: "the-stack-and-the-heap", Text: "The Stack and the Heap"},
+		{SourceAnchor: "dangling-references", TargetFile: "ch10-03-lifetime-syntax.html", Text: "Chapter 10"},
+	}
+	if err := database.ReplaceLinks(source, links); err != nil {
+		t.Fatalf("replace links: %v", err)
+	}
+
+	out, err := database.LinksFrom(source)
+	if err != nil {
+		t.Fatalf("links from: %v", err)
+	}
+	if len(out) != 2 || out[0].TargetAnchor != "the-stack-and-the-heap" || out[1].Text != "Chapter 10" {
+		t.Fatalf("unexpected outbound links: %+v", out)
+	}
+
+	in, err := database.LinksTo("ch04-01-what-is-ownership.html")
+	if err != nil {
+		t.Fatalf("links to: %v", err)
+	}
+	if len(in) != 1 || in[0].SourceFile != source || in[0].SourceAnchor != "references-and-borrowing" {
+		t.Fatalf("unexpected inbound links: %+v", in)
+	}
+
+	gone, err := database.LinksTo("ch99-00-gone.html")
+	if err != nil {
+		t.Fatalf("links to: %v", err)
+	}
+	if len(gone) != 0 {
+		t.Fatalf("expected stale links to be replaced, got %+v", gone)
+	}
+}
+
+func TestOpenMigratesEmbeddings(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), db.DefaultDBName)
+
+	// an index created before chunks recorded their overlap
+	conn, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	_, err = conn.Exec(`
+	CREATE TABLE embeddings (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		source_file TEXT NOT NULL,
+		chunk_index INTEGER NOT NULL,
+		content TEXT NOT NULL,
+		embedding BLOB NOT NULL
+	);
+	INSERT INTO embeddings (source_file, chunk_index, content, embedding)
+	VALUES ('ch04-01-what-is-ownership-parsed.txt', 0, 'Ownership is a set of rules', x'cdcccc3d');
+	`)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("create old schema: %v", err)
+	}
+
+	database, err := db.Open(dbPath)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+
+	if err := database.InsertEmbedding(db.Embedding{
+		SourceFile: "ch04-01-what-is-ownership-parsed.txt",
+		ChunkIndex: 1,
+		Content:    "set of rules that govern",
+		Start:      15,
+		End:        39,
+		Breadcrumb: "Chapter 4 › What Is Ownership?",
+		Overlap:    12,
+		Vector:     []float32{0.2},
+	}); err != nil {
+		t.Fatalf("insert embedding: %v", err)
+	}
+
+	stored, err := database.GetAllEmbeddings()
+	if err != nil {
+		t.Fatalf("get embeddings: %v", err)
+	}
+	if len(stored) != 2 || stored[0].Overlap != 0 || stored[0].Breadcrumb != "" || stored[0].End != 0 ||
+		stored[1].Overlap != 12 || stored[1].Breadcrumb != "Chapter 4 › What Is Ownership?" ||
+		stored[1].Start != 15 || stored[1].End != 39 {
+		t.Fatalf("unexpected embeddings after migration: %+v", stored)
+	}
+
+	// opening a migrated index again is a no-op
+	reopened, err := db.Open(dbPath)
+	if err != nil {
+		t.Fatalf("reopen db: %v", err)
+	}
+	reopened.Close()
+}
+
+func TestParents(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), db.DefaultDBName))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer database.Close()
+
+	const file = "ch04-01-what-is-ownership.md"
+	id, err := database.InsertParent(file, 0, "## Variable Scope\n\nA scope is the range...", "Variable Scope")
+	if err != nil {
+		t.Fatalf("insert parent: %v", err)
+	}
+	if err := database.InsertEmbedding(db.Embedding{SourceFile: file, ChunkIndex: 0, Content: "A scope is the range...", Start: 19, End: 42, Breadcrumb: "Variable Scope", ParentID: id, Vector: []float32{0.1}}); err != nil {
+		t.Fatalf("insert child: %v", err)
+	}
+	if err := database.InsertEmbedding(db.Embedding{SourceFile: file, ChunkIndex: 1, Content: "Other text", Start: 43, End: 53, Vector: []float32{0.2}}); err != nil {
+		t.Fatalf("insert embedding without parent: %v", err)
+	}
+
+	// storing the parent again updates it in place
+	again, err := database.InsertParent(file, 0, "## Variable Scope\n\nA scope is the range within a program.", "Variable Scope")
+	if err != nil {
+		t.Fatalf("insert parent again: %v", err)
+	}
+	if again != id {
+		t.Fatalf("expected parent id %d to be kept, got %d", id, again)
+	}
+
+	stored, err := database.GetAllEmbeddings()
+	if err != nil {
+		t.Fatalf("get embeddings: %v", err)
+	}
+	if len(stored) != 2 || stored[0].ParentID != id || stored[1].ParentID != 0 {
+		t.Fatalf("unexpected parent ids: %+v", stored)
+	}
+
+	parent, err := database.GetParent(id)
+	if err != nil {
+		t.Fatalf("get parent: %v", err)
+	}
+	if parent.SourceFile != file || parent.ParentIndex != 0 || parent.Content != "## Variable Scope\n\nA scope is the range within a program." {
+		t.Fatalf("unexpected parent: %+v", parent)
+	}
+
+	if _, err := database.GetParent(id + 1); err == nil {
+		t.Fatal("expected an error for a missing parent")
+	}
+	if err := database.InsertEmbedding(db.Embedding{SourceFile: file, ChunkIndex: 2, Content: "Orphan", Start: 54, End: 60, ParentID: id + 1, Vector: []float32{0.3}}); err == nil {
+		t.Fatal("expected an error for a child of a missing parent")
+	}
+}
@@ -163,3 +163,40 @@ func EmbedChunk(text string) ([]float32, error) {
 
 	return result.Embeddings[0].Values, nil
 }
+
+// batchSize is the most texts embedded in one request.
+const batchSize = 100
+
+// EmbedBatchWithClient generates one embedding per text, in order, sending
+// up to batchSize texts per request
+func EmbedBatchWithClient(texts []string, client EmbedClient) ([][]float32, error) {
+	out := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += batchSize {
+		batch := texts[start:min(start+batchSize, len(texts))]
+		contents := make([]*genai.Content, len(batch))
+		for i, text := range batch {
+			contents[i] = genai.NewContentFromText(text, genai.RoleUser)
+		}
+
+		result, err := client.EmbedContent(context.Background(), "gemini-embedding-001", contents, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(result.Embeddings) != len(batch) {
+			return nil, fmt.Errorf("expected %d embeddings, got %d", len(batch), len(result.Embeddings))
+		}
+		for _, e := range result.Embeddings {
+			out = append(out, e.Values)
+		}
+	}
+	return out, nil
+}
+
+// EmbedBatch creates a Gemini client and generates one embedding per text
+func EmbedBatch(texts []string) ([][]float32, error) {
+	client, err := genai.NewClient(context.Background(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Gemini client: %w", err)
+	}
+	return EmbedBatchWithClient(texts, &GeminiClient{client: client})
+}
@@ -46,6 +46,56 @@ func TestEmbedWithClient(t *testing.T) {
 	}
 }
 
+// batchClient returns one embedding per content, its index and the
+// number of contents in the request, and counts the requests
+type batchClient struct {
+	requests int
+}
+
+func (f *batchClient) EmbedContent(
+	ctx context.Context,
+	model string,
+	contents []*genai.Content,
+	options *genai.EmbedContentConfig,
+) (*genai.EmbedContentResponse, error) {
+	f.requests++
+	resp := &genai.EmbedContentResponse{}
+	for i := range contents {
+		resp.Embeddings = append(resp.Embeddings, &genai.ContentEmbedding{
+			Values: []float32{float32(i), float32(len(contents))},
+		})
+	}
+	return resp, nil
+}
+
+func TestEmbedBatchWithClient(t *testing.T) {
+	texts := make([]string, 250)
+	for i := range texts {
+		texts[i] = "sentence"
+	}
+
+	client := &batchClient{}
+	vecs, err := EmbedBatchWithClient(texts, client)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if client.requests != 3 {
+		t.Fatalf("expected 3 requests, got %d", client.requests)
+	}
+	if len(vecs) != len(texts) {
+		t.Fatalf("expected %d vectors, got %d", len(texts), len(vecs))
+	}
+	// the last request holds the 50 remaining texts
+	if last := vecs[249]; last[0] != 49 || last[1] != 50 {
+		t.Fatalf("expected the last vector to be [49 50], got %v", last)
+	}
+
+	if _, err := EmbedBatchWithClient([]string{"a", "b"}, &fakeClient{}); err == nil {
+		t.Fatal("expected error when fewer embeddings are returned, got nil")
+	}
+}
+
 func writeTempFile(t *testing.T, content []byte) string {
 	t.Helper()
 
@@ -0,0 +1,117 @@
+package parser
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// ArchiveMember is a file read from a tar or zip archive.
+type ArchiveMember struct {
+	// Name is the member's path inside the archive, with forward slashes.
+	Name string
+	Data []byte
+}
+
+// IsArchive reports whether name has the extension of an archive
+// ReadArchive understands: .zip, .tar, .tar.gz or .tgz.
+func IsArchive(name string) bool {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadArchive reads the regular files of the archive at archivePath for
+// which keep returns true, in archive order. Members whose path would
+// leave the archive ("../x", "/x") are ignored.
+func ReadArchive(archivePath string, keep func(name string) bool) ([]ArchiveMember, error) {
+	if strings.HasSuffix(strings.ToLower(archivePath), ".zip") {
+		return readZipArchive(archivePath, keep)
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	lower := strings.ToLower(archivePath)
+	if strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return readTarArchive(r, keep)
+}
+
+func readZipArchive(archivePath string, keep func(string) bool) ([]ArchiveMember, error) {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var members []ArchiveMember
+	for _, f := range zr.File {
+		name, ok := memberName(f.Name)
+		if !ok || !f.Mode().IsRegular() || !keep(name) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", f.Name, err)
+		}
+		members = append(members, ArchiveMember{Name: name, Data: data})
+	}
+	return members, nil
+}
+
+func readTarArchive(r io.Reader, keep func(string) bool) ([]ArchiveMember, error) {
+	tr := tar.NewReader(r)
+	var members []ArchiveMember
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return members, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name, ok := memberName(hdr.Name)
+		if !ok || hdr.Typeflag != tar.TypeReg || !keep(name) {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", hdr.Name, err)
+		}
+		members = append(members, ArchiveMember{Name: name, Data: data})
+	}
+}
+
+// memberName cleans an archive path and rejects paths outside the archive.
+func memberName(name string) (string, bool) {
+	name = path.Clean(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == ".." || strings.HasPrefix(name, "../") || strings.HasPrefix(name, "/") {
+		return "", false
+	}
+	return name, true
+}
@@ -0,0 +1,189 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// BlockKind names the kind of a typed block.
+type BlockKind string
+
+const (
+	// BlockFigure is an image with its alt text and caption.
+	BlockFigure BlockKind = "figure"
+	// BlockNote is an aside such as mdBook's <section class="note">.
+	BlockNote BlockKind = "note"
+	// BlockWarning is a warning callout.
+	BlockWarning BlockKind = "warning"
+)
+
+// Block is a figure or callout kept apart from the surrounding prose, so it
+// can be chunked as a unit and cited by kind.
+type Block struct {
+	Kind BlockKind `json:"kind"`
+	// Label is the figure number from the caption, e.g. "Figure 4-1".
+	Label   string `json:"label,omitempty"`
+	Src     string `json:"src,omitempty"`
+	Alt     string `json:"alt,omitempty"`
+	Caption string `json:"caption,omitempty"`
+	// Text is the Markdown body of a note or warning.
+	Text string `json:"text,omitempty"`
+}
+
+// sectionBlock is a typed block found after section headings, counted like
+// sectionListing.
+type sectionBlock struct {
+	section int
+	block   *Block
+}
+
+var (
+	figureLabel = regexp.MustCompile(`^(Figure\s+[0-9A-Z]+(?:[-.][0-9]+)*)\b`)
+	alertMarker = regexp.MustCompile(`(?m)^> \[!(NOTE|WARNING)\]$`)
+	figureLine  = regexp.MustCompile(`(?m)^!\[[^\n]*\]\([^)\n]*\)\n\n(Figure\s+[0-9A-Z]+(?:[-.][0-9]+)*)\b`)
+)
+
+// calloutKind returns the block kind of a note or warning container.
+func calloutKind(n *html.Node) BlockKind {
+	switch n.Data {
+	case "section", "aside", "div", "blockquote":
+	default:
+		return ""
+	}
+	switch {
+	case hasClass(n, "warning"):
+		return BlockWarning
+	case hasClass(n, "note"), attr(n, "role") == "note", attr(n, "aria-role") == "note":
+		return BlockNote
+	}
+	return ""
+}
+
+// renderCallout renders a note or warning as a GFM alert ("> [!NOTE]").
+// A heading that opens the callout stays a section heading, so asides such
+// as "The Stack and the Heap" remain citable; the rest forms the block.
+func (r *mdRenderer) renderCallout(n *html.Node, kind BlockKind) {
+	c := n.FirstChild
+	for c != nil && c.Type == html.TextNode && strings.TrimSpace(c.Data) == "" {
+		c = c.NextSibling
+	}
+	if c != nil && c.Type == html.ElementNode && len(c.Data) == 2 && c.Data[0] == 'h' && c.Data[1] >= '1' && c.Data[1] <= '6' {
+		r.walk(c)
+		c = c.NextSibling
+	}
+
+	sub := r.sub()
+	for ; c != nil; c = c.NextSibling {
+		sub.walk(c)
+	}
+	sub.flush()
+	body := strings.Join(sub.blocks, "\n\n")
+	if body == "" {
+		return
+	}
+
+	r.addTyped(&Block{Kind: kind, Text: body})
+	r.addBlock("> [!" + strings.ToUpper(string(kind)) + "]\n" + prefixLines(body, "> "))
+}
+
+// soleImage returns the <img> of a paragraph that holds nothing else,
+// mdBook's way of placing a figure.
+func soleImage(n *html.Node) *html.Node {
+	if n.Type != html.ElementNode || n.Data != "p" {
+		return nil
+	}
+	var img *html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch {
+		case c.Type == html.TextNode && strings.TrimSpace(c.Data) == "":
+		case c.Type == html.ElementNode && c.Data == "img" && img == nil:
+			img = c
+		default:
+			return nil
+		}
+	}
+	return img
+}
+
+// captionAfter returns the paragraph following n and its mdBook
+// <span class="caption">, if it has one.
+func captionAfter(n *html.Node) (*html.Node, *html.Node) {
+	next := n.NextSibling
+	for next != nil && next.Type == html.TextNode && strings.TrimSpace(next.Data) == "" {
+		next = next.NextSibling
+	}
+	if next == nil || next.Type != html.ElementNode || next.Data != "p" {
+		return nil, nil
+	}
+	caption := findFirst(next, selector{tag: "span", classes: []string{"caption"}})
+	if caption == nil {
+		return nil, nil
+	}
+	return next, caption
+}
+
+// renderFigure renders an image and its optional caption as a figure block:
+// a Markdown image followed by the caption paragraph.
+func (r *mdRenderer) renderFigure(img, caption *html.Node) {
+	fig := &Block{
+		Kind: BlockFigure,
+		Src:  attr(img, "src"),
+		Alt:  strings.Join(strings.Fields(attr(img, "alt")), " "),
+	}
+	if caption != nil {
+		fig.Caption = r.renderInline(caption)
+		if m := figureLabel.FindStringSubmatch(textContent(caption)); m != nil {
+			fig.Label = strings.Join(strings.Fields(m[1]), " ")
+		}
+	}
+	if fig.Src == "" && fig.Alt == "" && fig.Caption == "" {
+		return
+	}
+
+	r.addTyped(fig)
+	blocks := []string{imageMarkdown(fig.Alt, fig.Src)}
+	if fig.Caption != "" {
+		blocks = append(blocks, fig.Caption)
+	}
+	r.addBlock(strings.Join(blocks, "\n\n"))
+}
+
+// renderFigureElement renders a <figure> that is not a code listing.
+func (r *mdRenderer) renderFigureElement(n *html.Node) {
+	img := findFirst(n, selector{tag: "img"})
+	caption := findFirst(n, selector{tag: "figcaption"})
+	if img == nil {
+		r.flush()
+		r.walkChildren(n)
+		r.flush()
+		return
+	}
+	r.renderFigure(img, caption)
+}
+
+func (r *mdRenderer) addTyped(b *Block) {
+	r.root.typed = append(r.root.typed, sectionBlock{
+		section: len(r.root.headings),
+		block:   b,
+	})
+}
+
+func imageMarkdown(alt, src string) string {
+	alt = strings.NewReplacer("[", `\[`, "]", `\]`).Replace(alt)
+	src = strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(src)
+	return "![" + alt + "](" + src + ")"
+}
+
+// BlockOrigin describes the typed block a chunk of Markdown output comes
+// from, "figure 4-1", "a note" or "a warning", or "" for plain prose.
+func BlockOrigin(chunk string) string {
+	if m := figureLine.FindStringSubmatch(chunk); m != nil {
+		return strings.ToLower(m[1][:1]) + m[1][1:]
+	}
+	if m := alertMarker.FindStringSubmatch(chunk); m != nil {
+		return "a " + strings.ToLower(m[1])
+	}
+	return ""
+}
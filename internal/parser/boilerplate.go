@@ -0,0 +1,196 @@
+package parser
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// BoilerplateFileName is the boilerplate report written next to parsed output.
+const BoilerplateFileName = "boilerplate.json"
+
+// elements that hold blocks of text besides the Markdown block elements;
+// only the innermost block elements are compared across pages, so a footer
+// <div> holding two paragraphs is matched paragraph by paragraph
+var textBlockElements = map[string]bool{
+	"blockquote": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "ol": true, "p": true, "pre": true, "ul": true,
+}
+
+// Boilerplate is the set of text blocks repeated across a corpus: the
+// book title in a header, "Keyboard shortcuts" help, print-page notices,
+// license footers. Setting Options.Boilerplate removes them from parsed
+// output. Code blocks and tables are never treated as boilerplate.
+type Boilerplate struct {
+	// Fraction is the share of documents a block had to exceed.
+	Fraction float64 `json:"fraction"`
+	// Documents is the number of documents the blocks were counted in.
+	Documents int `json:"documents"`
+	// Blocks are the repeated blocks, most frequent first.
+	Blocks []BoilerplateBlock `json:"blocks"`
+
+	texts map[string]bool
+}
+
+// BoilerplateBlock is one repeated block and the number of documents it
+// appears in.
+type BoilerplateBlock struct {
+	Text      string `json:"text"`
+	Documents int    `json:"documents"`
+}
+
+// BoilerplateCounter counts in how many documents each block occurs.
+// The zero value is ready to use; it is not safe for concurrent use.
+type BoilerplateCounter struct {
+	documents int
+	counts    map[string]int
+}
+
+// Add counts the blocks of one document. Blocks repeated within the
+// document count once.
+func (c *BoilerplateCounter) Add(blocks []string) {
+	if c.counts == nil {
+		c.counts = map[string]int{}
+	}
+	c.documents++
+	seen := map[string]bool{}
+	for _, b := range blocks {
+		if !seen[b] {
+			seen[b] = true
+			c.counts[b]++
+		}
+	}
+}
+
+// Boilerplate returns the blocks found in more than fraction of the
+// documents counted so far. A block must occur in at least two documents,
+// so a single page is never stripped of its own text.
+func (c *BoilerplateCounter) Boilerplate(fraction float64) *Boilerplate {
+	b := &Boilerplate{Fraction: fraction, Documents: c.documents, texts: map[string]bool{}}
+	for text, n := range c.counts {
+		if n >= 2 && float64(n) > fraction*float64(c.documents) {
+			b.Blocks = append(b.Blocks, BoilerplateBlock{Text: text, Documents: n})
+			b.texts[text] = true
+		}
+	}
+	sort.Slice(b.Blocks, func(i, j int) bool {
+		if b.Blocks[i].Documents != b.Blocks[j].Documents {
+			return b.Blocks[i].Documents > b.Blocks[j].Documents
+		}
+		return b.Blocks[i].Text < b.Blocks[j].Text
+	})
+	return b
+}
+
+// Save writes the boilerplate report as indented JSON.
+func (b *Boilerplate) Save(path string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// fingerprint identifies the removed blocks for VersionFor.
+func (b *Boilerplate) fingerprint() string {
+	texts := make([]string, len(b.Blocks))
+	for i, block := range b.Blocks {
+		texts[i] = block.Text
+	}
+	sort.Strings(texts)
+	return HashBytes([]byte(strings.Join(texts, "\x00")))
+}
+
+// remove detaches the blocks of root whose text is boilerplate.
+func (b *Boilerplate) remove(root *html.Node) {
+	if b == nil || len(b.texts) == 0 {
+		return
+	}
+	var drop []*html.Node
+	walkBlocks(root, func(n *html.Node, text string) {
+		if b.texts[text] {
+			drop = append(drop, n)
+		}
+	})
+	for _, n := range drop {
+		n.Parent.RemoveChild(n)
+	}
+}
+
+// BoilerplateBlocks returns the normalized text of the innermost block
+// elements of the page's content, after the same selectors Parse applies.
+// Redirect stubs yield a *RedirectError, since they have no content.
+func BoilerplateBlocks(r io.Reader, opts Options) ([]string, error) {
+	doc, err := parseHTML(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	if target, ok := redirectTarget(doc); ok {
+		return nil, &RedirectError{Target: target}
+	}
+
+	opts.Boilerplate = nil
+	root, err := selectContent(doc, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []string
+	walkBlocks(root, func(_ *html.Node, text string) {
+		blocks = append(blocks, text)
+	})
+	return blocks, nil
+}
+
+// BoilerplateBlocksFile opens inputPath and returns its blocks.
+func BoilerplateBlocksFile(inputPath string, opts Options) ([]string, error) {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if !opts.Unbuffered {
+		r = bufio.NewReader(file)
+	}
+	return BoilerplateBlocks(r, opts)
+}
+
+// walkBlocks calls fn for every non-empty innermost block element under n,
+// skipping code listings and tables.
+func walkBlocks(n *html.Node, fn func(*html.Node, string)) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || skippedElements[c.Data] || c.Data == "pre" || c.Data == "table" {
+			continue
+		}
+		if isTextBlock(c.Data) && !hasBlockChild(c) {
+			if text := strings.Join(strings.Fields(textContent(c)), " "); text != "" {
+				fn(c, text)
+			}
+			continue
+		}
+		walkBlocks(c, fn)
+	}
+}
+
+func isTextBlock(tag string) bool {
+	return blockElements[tag] || textBlockElements[tag]
+}
+
+func hasBlockChild(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		if isTextBlock(c.Data) || hasBlockChild(c) {
+			return true
+		}
+	}
+	return false
+}
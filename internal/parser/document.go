@@ -0,0 +1,278 @@
+package parser
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Document is the structured form of a parsed page: its title, where it
+// sits in the book and the sections under each heading.
+type Document struct {
+	// Source is the file name the document was parsed from.
+	Source string `json:"source"`
+	// Title is the page title without the book name suffix.
+	Title string `json:"title"`
+	// Book is the book name taken from "<page> - <book>" titles.
+	Book string `json:"book,omitempty"`
+	// Number is the chapter number derived from the file name,
+	// "4.1" for ch04-01-*.html, "4" for ch04-00-*.html, "A" for appendix-01-*.
+	Number string `json:"number,omitempty"`
+	// Order is the 1-based reading order for documents that come from a
+	// book's table of contents, 0 for standalone pages.
+	Order int `json:"order,omitempty"`
+	// Parent is the source of the enclosing chapter, if any.
+	Parent string `json:"parent,omitempty"`
+	// Item describes the API item of a rustdoc page.
+	Item     *Item     `json:"item,omitempty"`
+	Sections []Section `json:"sections"`
+}
+
+// Section is the content under one heading, up to the next heading.
+// Content before the first heading forms a section with an empty heading.
+type Section struct {
+	Heading  string    `json:"heading,omitempty"`
+	Anchor   string    `json:"anchor,omitempty"`
+	Level    int       `json:"level,omitempty"`
+	Body     string    `json:"body"`
+	Listings []Listing `json:"listings,omitempty"`
+	// Blocks are the figures, notes and warnings in the section.
+	Blocks []Block `json:"blocks,omitempty"`
+	// Links are the section's references to pages of the same book.
+	Links []Link `json:"links,omitempty"`
+}
+
+var (
+	chapterName  = regexp.MustCompile(`^ch(\d+)-(\d+)`)
+	appendixName = regexp.MustCompile(`^appendix-(\d+)`)
+)
+
+// ChapterNumber derives the chapter number from a Rust Book file name:
+// "ch04-01-what-is-ownership.html" is "4.1", "ch04-00-..." is "4" and
+// "appendix-02-operators.html" is "B". Unnumbered pages return "".
+func ChapterNumber(name string) string {
+	base := filepath.Base(name)
+	if m := chapterName.FindStringSubmatch(base); m != nil {
+		chapter, _ := strconv.Atoi(m[1])
+		section, _ := strconv.Atoi(m[2])
+		switch {
+		case chapter == 0:
+			return ""
+		case section == 0:
+			return strconv.Itoa(chapter)
+		default:
+			return fmt.Sprintf("%d.%d", chapter, section)
+		}
+	}
+	if m := appendixName.FindStringSubmatch(base); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		if n >= 1 && n <= 26 {
+			return string(rune('A' + n - 1))
+		}
+	}
+	return ""
+}
+
+// Label names the document's place in the book, e.g. "Chapter 4.1" or
+// "Appendix B", falling back to the title for unnumbered pages.
+func (d *Document) Label() string {
+	switch {
+	case d.Number == "":
+		return d.Title
+	case d.Number[0] >= 'A' && d.Number[0] <= 'Z':
+		return "Appendix " + d.Number
+	default:
+		return "Chapter " + d.Number
+	}
+}
+
+// Citation formats a reference to a section,
+// e.g. "Chapter 4.1 › The Stack and the Heap".
+func (d *Document) Citation(s *Section) string {
+	label := d.Label()
+	if s == nil || s.Heading == "" {
+		return label
+	}
+	if label == "" {
+		return s.Heading
+	}
+	return label + " › " + s.Heading
+}
+
+// Markdown joins the sections back into a single Markdown document.
+func (d *Document) Markdown() string {
+	var parts []string
+	for _, s := range d.Sections {
+		if s.Heading != "" {
+			parts = append(parts, strings.Repeat("#", max(s.Level, 1))+" "+s.Heading)
+		}
+		if s.Body != "" {
+			parts = append(parts, s.Body)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// Text flattens the document into whitespace-joined prose with tables as
+// tab-separated rows, the same shape FormatText produces for HTML pages:
+// headings, fences and inline markup are dropped, code keeps its text.
+func (d *Document) Text() string {
+	return markdownText(d.Markdown())
+}
+
+// Render formats the document for output in the given format.
+func (d *Document) Render(format Format) (string, error) {
+	switch format {
+	case FormatMarkdown:
+		return d.Markdown(), nil
+	case FormatJSONL:
+		data, err := json.Marshal(d)
+		return string(data), err
+	}
+	return d.Text(), nil
+}
+
+// ParseDocument reads an HTML page from r and builds its Document.
+// name is the source file name, used for chapter numbering.
+// Redirect stubs yield a *RedirectError.
+func ParseDocument(r io.Reader, name string, opts Options) (*Document, error) {
+	doc, err := parseHTML(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	return buildDocument(doc, name, opts)
+}
+
+// ParseDocumentFile opens inputPath and builds its Document.
+func ParseDocumentFile(inputPath string, opts Options) (*Document, error) {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if !opts.Unbuffered {
+		r = bufio.NewReader(file)
+	}
+	return ParseDocument(r, filepath.Base(inputPath), opts)
+}
+
+func buildDocument(doc *html.Node, name string, opts Options) (*Document, error) {
+	if target, ok := redirectTarget(doc); ok {
+		return nil, &RedirectError{Target: target}
+	}
+	if usesRustdoc(doc, opts) {
+		return buildRustdocDocument(doc, name, opts)
+	}
+
+	title, book := splitTitle(pageTitle(doc))
+
+	root, err := selectContent(doc, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	r := newRenderer(opts)
+	r.source = name
+	r.walk(root)
+	r.flush()
+
+	return &Document{
+		Source:   name,
+		Title:    title,
+		Book:     book,
+		Number:   ChapterNumber(name),
+		Sections: r.sections(),
+	}, nil
+}
+
+// sections splits the root renderer's blocks at its top-level headings.
+func (r *mdRenderer) sections() []Section {
+	var sections []Section
+
+	bodyEnd := len(r.blocks)
+	if len(r.headings) > 0 {
+		bodyEnd = r.headings[0].block
+	}
+	if bodyEnd > 0 {
+		sections = append(sections, Section{Body: strings.Join(r.blocks[:bodyEnd], "\n\n")})
+	}
+
+	for i, h := range r.headings {
+		end := len(r.blocks)
+		if i+1 < len(r.headings) {
+			end = r.headings[i+1].block
+		}
+		sections = append(sections, Section{
+			Heading: h.text,
+			Anchor:  h.anchor,
+			Level:   h.level,
+			Body:    strings.Join(r.blocks[h.block+1:end], "\n\n"),
+		})
+	}
+
+	// listings, typed blocks and links before the first heading belong to
+	// the preamble section, which only exists when there was content before
+	// that heading
+	offset := 0
+	if bodyEnd == 0 {
+		offset = -1
+	}
+	for _, l := range r.listings {
+		if i := l.section + offset; i >= 0 && i < len(sections) {
+			sections[i].Listings = append(sections[i].Listings, *l.listing)
+		}
+	}
+	for _, b := range r.typed {
+		if i := b.section + offset; i >= 0 && i < len(sections) {
+			sections[i].Blocks = append(sections[i].Blocks, *b.block)
+		}
+	}
+	for _, l := range r.links {
+		if i := l.section + offset; i >= 0 && i < len(sections) {
+			sections[i].Links = append(sections[i].Links, l.link)
+		}
+	}
+
+	return sections
+}
+
+func pageTitle(doc *html.Node) string {
+	if n := findFirst(doc, selector{tag: "title"}); n != nil {
+		return strings.Join(strings.Fields(textContent(n)), " ")
+	}
+	return ""
+}
+
+// splitTitle separates mdBook's "<page> - <book>" titles.
+func splitTitle(full string) (title, book string) {
+	if i := strings.LastIndex(full, " - "); i > 0 {
+		return full[:i], full[i+3:]
+	}
+	return full, ""
+}
+
+// headingAnchor returns the id a heading can be linked to, either its own
+// id or the fragment of the mdBook <a class="header"> inside it.
+func headingAnchor(n *html.Node) string {
+	if id := attr(n, "id"); id != "" {
+		return id
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == "a" {
+			if href := attr(c, "href"); strings.HasPrefix(href, "#") {
+				return href[1:]
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,145 @@
+package parser
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// EPUB is an EPUB book read into memory: its title and the XHTML documents
+// of its spine, in reading order.
+type EPUB struct {
+	Title string
+	Items []EPUBItem
+}
+
+// EPUBItem is one spine entry of an EPUB.
+type EPUBItem struct {
+	// Href is the item's path inside the container.
+	Href string
+	Data []byte
+}
+
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath  string `xml:"full-path,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type epubPackage struct {
+	Title    string `xml:"metadata>title"`
+	Manifest []struct {
+		ID        string `xml:"id,attr"`
+		Href      string `xml:"href,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"manifest>item"`
+	Spine []struct {
+		IDRef string `xml:"idref,attr"`
+	} `xml:"spine>itemref"`
+}
+
+// ReadEPUB opens the EPUB at epubPath, follows META-INF/container.xml to
+// the OPF package document and reads every (X)HTML item of the spine.
+func ReadEPUB(epubPath string) (*EPUB, error) {
+	zr, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	var container epubContainer
+	if err := readZipXML(files, "META-INF/container.xml", &container); err != nil {
+		return nil, err
+	}
+	if len(container.Rootfiles) == 0 {
+		return nil, errors.New("epub: container.xml lists no rootfile")
+	}
+	opfPath := container.Rootfiles[0].FullPath
+
+	var pkg epubPackage
+	if err := readZipXML(files, opfPath, &pkg); err != nil {
+		return nil, err
+	}
+
+	hrefs := make(map[string]string, len(pkg.Manifest))
+	for _, item := range pkg.Manifest {
+		if item.MediaType == "application/xhtml+xml" || item.MediaType == "text/html" {
+			hrefs[item.ID] = item.Href
+		}
+	}
+
+	book := &EPUB{Title: strings.TrimSpace(pkg.Title)}
+	for _, ref := range pkg.Spine {
+		href, ok := hrefs[ref.IDRef]
+		if !ok {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(href); err == nil {
+			href = unescaped
+		}
+		name := path.Join(path.Dir(opfPath), href)
+
+		data, err := readZipFile(files, name)
+		if err != nil {
+			return nil, err
+		}
+		book.Items = append(book.Items, EPUBItem{Href: name, Data: data})
+	}
+
+	if len(book.Items) == 0 {
+		return nil, errors.New("epub: spine has no XHTML items")
+	}
+	return book, nil
+}
+
+// ParseItem builds the Document for spine item i, ordered by its position
+// in the spine.
+func (e *EPUB) ParseItem(i int, opts Options) (*Document, error) {
+	item := e.Items[i]
+	doc, err := ParseDocument(bytes.NewReader(item.Data), path.Base(item.Href), opts)
+	if err != nil {
+		return nil, err
+	}
+	doc.Source = item.Href
+	doc.Order = i + 1
+	if e.Title != "" {
+		doc.Book = e.Title
+	}
+	return doc, nil
+}
+
+func readZipFile(files map[string]*zip.File, name string) ([]byte, error) {
+	f, ok := files[name]
+	if !ok {
+		return nil, fmt.Errorf("epub: missing %s", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func readZipXML(files map[string]*zip.File, name string, v any) error {
+	data, err := readZipFile(files, name)
+	if err != nil {
+		return err
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("epub: decode %s: %w", name, err)
+	}
+	return nil
+}
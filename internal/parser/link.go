@@ -0,0 +1,116 @@
+package parser
+
+import (
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Link is a hyperlink from a section to a page of the same book.
+type Link struct {
+	// Target is the linked page, resolved relative to the linking page.
+	Target string `json:"target"`
+	Anchor string `json:"anchor,omitempty"`
+	Text   string `json:"text,omitempty"`
+}
+
+// sectionLink is a link found after section headings, counted like
+// sectionListing.
+type sectionLink struct {
+	section int
+	link    Link
+}
+
+var (
+	mdInlineLink = regexp.MustCompile(`\[([^\]]+)\]\(\s*<?([^)\s>]+)>?(?:\s+"[^"]*")?\s*\)`)
+	mdRefLink    = regexp.MustCompile(`\[([^\]]+)\]\[([^\]]*)\]`)
+	mdLinkDef    = regexp.MustCompile(`^\s{0,3}\[([^\]]+)\]:\s*<?(\S+?)>?(?:\s+.*)?$`)
+	mdInlineCode = regexp.MustCompile("`+[^`]*`+")
+)
+
+// internalLink resolves href found on page source. Links to other sites,
+// absolute paths and pages outside the book are not internal.
+func internalLink(href, source, text string) (Link, bool) {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil || u.Scheme != "" || u.Host != "" || strings.HasPrefix(u.Path, "/") {
+		return Link{}, false
+	}
+	if u.Path == "" && u.Fragment == "" {
+		return Link{}, false
+	}
+
+	target := path.Base(source)
+	if u.Path != "" {
+		target = path.Clean(path.Join(path.Dir(source), u.Path))
+		if target == ".." || strings.HasPrefix(target, "../") {
+			return Link{}, false
+		}
+	}
+	return Link{
+		Target: target,
+		Anchor: u.Fragment,
+		Text:   strings.Join(strings.Fields(text), " "),
+	}, true
+}
+
+// PageName maps any name a page goes by to one key, so links can be matched
+// across formats: "ch04-01-what-is-ownership.md", "OEBPS/ch04-01-what-is-ownership.xhtml"
+// and the parsed output "ch04-01-what-is-ownership-parsed.txt" are all
+// "ch04-01-what-is-ownership.html".
+func PageName(name string) string {
+	base := path.Base(filepath.ToSlash(name))
+	switch ext := path.Ext(base); ext {
+	case ".html", ".htm", ".xhtml", ".md", ".txt", ".jsonl":
+		base = strings.TrimSuffix(base, ext)
+	}
+	return strings.TrimSuffix(base, "-parsed") + ".html"
+}
+
+// markdownLinks collects the inline and reference links of Markdown source
+// lines in document order. Reference links are resolved against defs.
+func markdownLinks(line, source string, defs map[string]string) []Link {
+	line = mdInlineCode.ReplaceAllString(line, "")
+
+	type match struct {
+		at         int
+		text, href string
+	}
+	var found []match
+	for _, m := range mdInlineLink.FindAllStringSubmatchIndex(line, -1) {
+		found = append(found, match{m[0], line[m[2]:m[3]], line[m[4]:m[5]]})
+	}
+	for _, m := range mdRefLink.FindAllStringSubmatchIndex(line, -1) {
+		text, ref := line[m[2]:m[3]], line[m[4]:m[5]]
+		if ref == "" {
+			ref = text
+		}
+		if href, ok := defs[strings.ToLower(ref)]; ok {
+			found = append(found, match{m[0], text, href})
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].at < found[j].at })
+
+	var links []Link
+	for _, m := range found {
+		if l, ok := internalLink(m.href, source, mdInlineLink.ReplaceAllString(m.text, "$1")); ok {
+			links = append(links, l)
+		}
+	}
+	return links
+}
+
+// markdownLinkDefs collects the reference definitions ("[name]: url") of a
+// Markdown source, keyed by lower-cased name.
+func markdownLinkDefs(src string) map[string]string {
+	defs := map[string]string{}
+	for _, line := range strings.Split(src, "\n") {
+		if m := mdLinkDef.FindStringSubmatch(line); m != nil {
+			defs[strings.ToLower(m[1])] = m[2]
+		}
+	}
+	return defs
+}
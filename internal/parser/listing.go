@@ -0,0 +1,29 @@
+package parser
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Listing describes a code listing from an mdBook page. ID, FileName and
+// Caption come from <figure class="listing">; loose <pre> blocks have none.
+type Listing struct {
+	ID       string `json:"id,omitempty"`
+	Language string `json:"language,omitempty"`
+	FileName string `json:"file_name,omitempty"`
+	Caption  string `json:"caption,omitempty"`
+	Code     string `json:"code"`
+}
+
+// isBoring reports whether n is one of mdBook's hidden code lines,
+// e.g. the fn main() { ... } wrapper around short examples.
+func isBoring(n *html.Node) bool {
+	return n.Data == "span" && hasClass(n, "boring")
+}
+
+// fileNameLabel strips the "Filename:" label from a <span class="file-name">.
+func fileNameLabel(n *html.Node) string {
+	text := strings.TrimSpace(textContent(n))
+	return strings.TrimSpace(strings.TrimPrefix(text, "Filename:"))
+}
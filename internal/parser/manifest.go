@@ -0,0 +1,143 @@
+package parser
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ManifestFileName is the manifest written next to parsed output.
+const ManifestFileName = "manifest.json"
+
+// Version identifies the extraction logic. Bump it whenever the output for
+// an unchanged input would differ, so incremental runs re-parse everything.
+const Version = "8"
+
+// Manifest records, for every parsed source, the hashes needed to decide
+// whether it has to be parsed again.
+type Manifest struct {
+	Entries map[string]ManifestEntry `json:"entries"`
+	// Normalization is the text normalization of the last run, which
+	// search applies to queries so they match the parsed text.
+	Normalization Normalization `json:"normalization,omitzero"`
+}
+
+// ManifestEntry describes one source file and the output produced from it.
+type ManifestEntry struct {
+	Source string `json:"source"`
+	// Member is the document inside Source for container formats such as
+	// EPUB, empty for plain files.
+	Member        string `json:"member,omitempty"`
+	SourceHash    string `json:"source_hash"`
+	ParserVersion string `json:"parser_version"`
+	// Output is the output file name inside the output directory,
+	// empty for redirect stubs.
+	Output     string `json:"output,omitempty"`
+	OutputHash string `json:"output_hash,omitempty"`
+	// Redirect is the canonical page of a redirect stub.
+	Redirect string `json:"redirect,omitempty"`
+	// Skipped marks sources with nothing to extract, such as rustdoc
+	// source views.
+	Skipped bool `json:"skipped,omitempty"`
+	// Links reports whether the source's links were stored in the index.
+	Links bool `json:"links,omitempty"`
+}
+
+// Key returns the key the entry is stored under in Manifest.Entries.
+func (e ManifestEntry) Key() string {
+	return ManifestKey(e.Source, e.Member)
+}
+
+// ManifestKey joins a source path and an optional member name into a
+// manifest key, "book.epub!OEBPS/ch01.xhtml" for EPUB items.
+func ManifestKey(source, member string) string {
+	if member == "" {
+		return source
+	}
+	return source + "!" + member
+}
+
+// LoadManifest reads a manifest, returning an empty one if the file does
+// not exist yet.
+func LoadManifest(path string) (*Manifest, error) {
+	m := &Manifest{Entries: map[string]ManifestEntry{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	if m.Entries == nil {
+		m.Entries = map[string]ManifestEntry{}
+	}
+	return m, nil
+}
+
+// Save writes the manifest as indented JSON.
+func (m *Manifest) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// VersionFor combines Version with the options that change the output, so a
+// manifest entry written with different options is considered stale.
+func VersionFor(opts Options) string {
+	format := opts.Format
+	if format == "" {
+		format = FormatText
+	}
+	profile := opts.Profile
+	if profile == "" {
+		profile = ProfileAuto
+	}
+	fields := []string{
+		string(format),
+		string(profile),
+		fmt.Sprint(opts.KeepBoring),
+		fmt.Sprint(opts.ContentSelectors),
+		fmt.Sprint(opts.ExcludeSelectors),
+	}
+	if opts.Boilerplate != nil {
+		fields = append(fields, opts.Boilerplate.fingerprint())
+	}
+	if !opts.Normalization.IsZero() {
+		fields = append(fields, fmt.Sprintf("%+v", opts.Normalization))
+	}
+	fingerprint := strings.Join(fields, "\x00")
+	return Version + "-" + HashBytes([]byte(fingerprint))[:12]
+}
+
+// HashBytes returns the hex encoded SHA-256 of data.
+func HashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// HashFile returns the hex encoded SHA-256 of the file at path.
+func HashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,488 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// elements that start a new Markdown block when they open and close
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "body": true,
+	"dd": true, "details": true, "dialog": true, "div": true, "dl": true,
+	"dt": true, "fieldset": true, "figcaption": true, "figure": true,
+	"footer": true, "form": true, "header": true, "hgroup": true,
+	"html": true, "li": true, "main": true, "nav": true, "section": true,
+	"summary": true, "table": true, "tbody": true, "td": true, "tfoot": true,
+	"th": true, "thead": true, "tr": true,
+}
+
+// elements whose content is never part of the readable page
+var skippedElements = map[string]bool{
+	"head": true, "iframe": true, "noscript": true, "script": true,
+	"style": true, "template": true,
+}
+
+// mdRenderer walks an HTML node tree and collects Markdown blocks.
+// Inline content is buffered until a block boundary flushes it as a paragraph.
+type mdRenderer struct {
+	opts         Options
+	blocks       []string
+	inline       strings.Builder
+	pendingSpace bool
+
+	// root is the top-level renderer. Nested renderers used for list items
+	// and block quotes report listings and typed blocks to it; only the
+	// root splits sections.
+	root     *mdRenderer
+	headings []headingMark
+	listings []sectionListing
+	typed    []sectionBlock
+	links    []sectionLink
+
+	// source is the page name links are resolved against, set on the root
+	source string
+}
+
+// headingMark records a top-level heading and the index of its block.
+type headingMark struct {
+	block  int
+	level  int
+	text   string
+	anchor string
+}
+
+// sectionListing is a listing found after section headings, so 0 means
+// it came before the first heading.
+type sectionListing struct {
+	section int
+	listing *Listing
+}
+
+func newRenderer(opts Options) *mdRenderer {
+	r := &mdRenderer{opts: opts}
+	r.root = r
+	return r
+}
+
+func (r *mdRenderer) sub() *mdRenderer {
+	return &mdRenderer{opts: r.opts, root: r.root}
+}
+
+// renderChildren renders only the children of n, used for nested
+// containers such as list items and block quotes.
+func (r *mdRenderer) renderChildren(n *html.Node) string {
+	sub := r.sub()
+	sub.walkChildren(n)
+	sub.flush()
+	return strings.Join(sub.blocks, "\n\n")
+}
+
+// renderInline renders the children of n as a single line of inline Markdown.
+func (r *mdRenderer) renderInline(n *html.Node) string {
+	sub := r.sub()
+	sub.walkChildren(n)
+	sub.flush()
+	return strings.Join(strings.Fields(strings.Join(sub.blocks, " ")), " ")
+}
+
+func (r *mdRenderer) walkChildren(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if img := soleImage(c); img != nil {
+			next, caption := captionAfter(c)
+			r.renderFigure(img, caption)
+			if next != nil {
+				c = next
+			}
+			continue
+		}
+		r.walk(c)
+	}
+}
+
+func (r *mdRenderer) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		r.writeText(n.Data)
+		return
+	case html.DocumentNode:
+		r.walkChildren(n)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	if skippedElements[n.Data] {
+		return
+	}
+	if kind := calloutKind(n); kind != "" {
+		r.renderCallout(n, kind)
+		return
+	}
+
+	switch n.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level := int(n.Data[1] - '0')
+		if text := r.renderInline(n); text != "" {
+			r.flush()
+			if r == r.root {
+				r.headings = append(r.headings, headingMark{
+					block:  len(r.blocks),
+					level:  level,
+					text:   text,
+					anchor: headingAnchor(n),
+				})
+			}
+			r.addBlock(strings.Repeat("#", level) + " " + text)
+		}
+	case "p":
+		r.flush()
+		r.walkChildren(n)
+		r.flush()
+	case "ul", "ol":
+		r.addBlock(r.renderList(n))
+	case "pre":
+		r.addBlock(r.renderPre(n, nil))
+	case "figure":
+		if hasClass(n, "listing") {
+			r.addBlock(r.renderListing(n))
+		} else {
+			r.renderFigureElement(n)
+		}
+	case "a":
+		// mdBook's heading self-links are anchors, not references
+		if !hasClass(n, "header") {
+			if l, ok := internalLink(attr(n, "href"), r.root.source, textContent(n)); ok {
+				r.root.links = append(r.root.links, sectionLink{section: len(r.root.headings), link: l})
+			}
+		}
+		r.walkChildren(n)
+	case "img":
+		if alt := strings.Join(strings.Fields(attr(n, "alt")), " "); alt != "" {
+			r.write(imageMarkdown(alt, attr(n, "src")))
+		}
+	case "table":
+		r.addBlock(buildTable(n, r.renderInline).markdown())
+	case "blockquote":
+		r.addBlock(prefixLines(r.renderChildren(n), "> "))
+	case "hr":
+		r.addBlock("---")
+	case "br":
+		r.inline.WriteString("\n")
+		r.pendingSpace = false
+	case "code", "kbd", "samp":
+		r.writeWrapped(n, codeSpan(textContent(n)))
+	case "em", "i":
+		r.writeWrapped(n, wrapNonEmpty("*", r.renderInline(n)))
+	case "strong", "b":
+		r.writeWrapped(n, wrapNonEmpty("**", r.renderInline(n)))
+	default:
+		if blockElements[n.Data] {
+			r.flush()
+			r.walkChildren(n)
+			r.flush()
+		} else {
+			r.walkChildren(n)
+		}
+	}
+}
+
+// flush turns buffered inline content into a paragraph block.
+func (r *mdRenderer) flush() {
+	text := strings.TrimSpace(r.inline.String())
+	r.inline.Reset()
+	r.pendingSpace = false
+	if text != "" {
+		r.blocks = append(r.blocks, text)
+	}
+}
+
+func (r *mdRenderer) addBlock(block string) {
+	r.flush()
+	if block != "" {
+		r.blocks = append(r.blocks, block)
+	}
+}
+
+// writeText appends a text node, collapsing runs of whitespace into one space.
+func (r *mdRenderer) writeText(s string) {
+	if s == "" {
+		return
+	}
+	first, _ := utf8.DecodeRuneInString(s)
+	last, _ := utf8.DecodeLastRuneInString(s)
+
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		r.pendingSpace = true
+		return
+	}
+	if unicode.IsSpace(first) {
+		r.pendingSpace = true
+	}
+	r.write(strings.Join(words, " "))
+	if unicode.IsSpace(last) {
+		r.pendingSpace = true
+	}
+}
+
+// writeWrapped appends already rendered inline markup for n, keeping the
+// whitespace that surrounded the element's text.
+func (r *mdRenderer) writeWrapped(n *html.Node, markup string) {
+	raw := textContent(n)
+	if markup == "" {
+		if strings.TrimSpace(raw) == "" && raw != "" {
+			r.pendingSpace = true
+		}
+		return
+	}
+	if first, _ := utf8.DecodeRuneInString(raw); unicode.IsSpace(first) {
+		r.pendingSpace = true
+	}
+	r.write(markup)
+	if last, _ := utf8.DecodeLastRuneInString(raw); unicode.IsSpace(last) {
+		r.pendingSpace = true
+	}
+}
+
+func (r *mdRenderer) write(s string) {
+	if r.pendingSpace && r.inline.Len() > 0 {
+		r.inline.WriteByte(' ')
+	}
+	r.pendingSpace = false
+	r.inline.WriteString(s)
+}
+
+// textContent returns the concatenated text of n and its descendants.
+func textContent(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+			return
+		}
+		if n.Type == html.ElementNode && skippedElements[n.Data] {
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return sb.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasClass(n *html.Node, class string) bool {
+	for _, c := range strings.Fields(attr(n, "class")) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// codeSpan wraps inline code in enough backticks to survive embedded ones.
+func codeSpan(code string) string {
+	code = strings.Join(strings.Fields(code), " ")
+	if code == "" {
+		return ""
+	}
+	if !strings.Contains(code, "`") {
+		return "`" + code + "`"
+	}
+	fence := strings.Repeat("`", longestRun(code, '`')+1)
+	return fence + " " + code + " " + fence
+}
+
+func wrapNonEmpty(marker, s string) string {
+	if s == "" {
+		return ""
+	}
+	return marker + s + marker
+}
+
+func (r *mdRenderer) renderList(n *html.Node) string {
+	ordered := n.Data == "ol"
+	num := 1
+	if start, err := strconv.Atoi(attr(n, "start")); err == nil {
+		num = start
+	}
+
+	var items []string
+	loose := false
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.Data != "li" {
+			continue
+		}
+		marker := "- "
+		if ordered {
+			marker = fmt.Sprintf("%d. ", num)
+			num++
+		}
+		body := r.renderChildren(c)
+		if strings.Contains(body, "\n\n") {
+			loose = true
+		}
+		items = append(items, marker+indentLines(body, len(marker)))
+	}
+
+	if loose {
+		return strings.Join(items, "\n\n")
+	}
+	return strings.Join(items, "\n")
+}
+
+// renderPre emits preformatted text as a fenced code block with its
+// original line breaks intact. mdBook's hidden "boring" lines are dropped
+// unless Options.KeepBoring is set.
+func (r *mdRenderer) renderPre(n *html.Node, meta *Listing) string {
+	var sb strings.Builder
+	writeCode(n, &sb, r.opts.KeepBoring)
+	code := strings.TrimRight(sb.String(), "\n")
+	if strings.TrimSpace(code) == "" {
+		return ""
+	}
+
+	if meta == nil {
+		meta = &Listing{}
+	}
+	meta.Language, meta.Code = codeLanguage(n), code
+	r.root.listings = append(r.root.listings, sectionListing{
+		section: len(r.root.headings),
+		listing: meta,
+	})
+
+	info := meta.Language
+	if meta.FileName != "" {
+		info += ` title="` + meta.FileName + `"`
+	}
+	return fenceCode(code, info)
+}
+
+// renderListing renders an mdBook <figure class="listing">: the code block
+// tagged with its file name, followed by the listing caption.
+func (r *mdRenderer) renderListing(n *html.Node) string {
+	meta := &Listing{ID: attr(n, "id")}
+	var pre, caption *html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		switch {
+		case hasClass(c, "file-name"):
+			meta.FileName = fileNameLabel(c)
+		case c.Data == "pre" && pre == nil:
+			pre = c
+		case c.Data == "figcaption":
+			caption = c
+		}
+	}
+
+	var blocks []string
+	if pre != nil {
+		if code := r.renderPre(pre, meta); code != "" {
+			blocks = append(blocks, code)
+		}
+	}
+	if caption != nil {
+		meta.Caption = r.renderInline(caption)
+		if meta.Caption != "" {
+			blocks = append(blocks, meta.Caption)
+		}
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+// writeCode collects the text of a code block, skipping <span class="boring">
+// lines unless keepBoring is set.
+func writeCode(n *html.Node, sb *strings.Builder, keepBoring bool) {
+	if n.Type == html.TextNode {
+		sb.WriteString(n.Data)
+		return
+	}
+	if n.Type == html.ElementNode && !keepBoring && isBoring(n) {
+		return
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		writeCode(c, sb, keepBoring)
+	}
+}
+
+// codeLanguage returns the language of the first <code class="language-*">
+// inside n, e.g. "rust" for class="language-rust edition2024".
+func codeLanguage(n *html.Node) string {
+	if n.Type == html.ElementNode {
+		for _, class := range strings.Fields(attr(n, "class")) {
+			if lang, ok := strings.CutPrefix(class, "language-"); ok {
+				return lang
+			}
+		}
+		// rustdoc marks Rust examples with a plain "rust" class
+		if n.Data == "pre" && hasClass(n, "rust") {
+			return "rust"
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if lang := codeLanguage(c); lang != "" {
+			return lang
+		}
+	}
+	return ""
+}
+
+func fenceCode(code, info string) string {
+	fence := strings.Repeat("`", max(3, longestRun(code, '`')+1))
+	return fence + info + "\n" + code + "\n" + fence
+}
+
+func longestRun(s string, ch rune) int {
+	longest, run := 0, 0
+	for _, c := range s {
+		if c == ch {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return longest
+}
+
+// indentLines indents every line after the first by width spaces so that
+// nested content stays inside its list item.
+func indentLines(s string, width int) string {
+	pad := strings.Repeat(" ", width)
+	lines := strings.Split(s, "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = pad + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func prefixLines(s, prefix string) string {
+	if s == "" {
+		return ""
+	}
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(prefix+line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,451 @@
+package parser
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// BookConfigName is the file that marks the root of an mdBook source tree.
+const BookConfigName = "book.toml"
+
+// Book is an mdBook source tree: the book.toml settings and the chapters
+// listed in SUMMARY.md, in reading order.
+type Book struct {
+	Title string
+	// Root is the directory holding book.toml.
+	Root string
+	// SrcDir is the directory chapter paths are relative to.
+	SrcDir   string
+	Chapters []BookChapter
+}
+
+// BookChapter is a SUMMARY.md entry that points at a Markdown file.
+type BookChapter struct {
+	Title string
+	// Path is the chapter file relative to the book's SrcDir.
+	Path string
+	// Number is the hierarchical chapter number, e.g. "4.1". Prefix and
+	// suffix chapters are unnumbered.
+	Number string
+	// Parent is the index of the enclosing chapter, or -1 at the top level.
+	Parent int
+}
+
+var (
+	summaryLink = regexp.MustCompile(`\[(.*)\]\((.*)\)`)
+	atxHeading  = regexp.MustCompile(`^(#{1,6})\s+(.*?)(?:\s+#+)?\s*$`)
+	headingID   = regexp.MustCompile(`\s*\{#([^}\s]+)\}$`)
+	inlineLink  = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	includeDir  = regexp.MustCompile(`\{\{#(include|rustdoc_include)\s+([^}]+?)\s*\}\}`)
+	htmlAttr    = regexp.MustCompile(`([a-zA-Z-]+)="([^"]*)"`)
+)
+
+// IsBook reports whether dir is the root of an mdBook source tree.
+func IsBook(dir string) bool {
+	info, err := os.Stat(filepath.Join(dir, BookConfigName))
+	return err == nil && !info.IsDir()
+}
+
+// LoadBook reads book.toml and SUMMARY.md from the mdBook source tree at dir.
+func LoadBook(dir string) (*Book, error) {
+	book := &Book{Root: dir, SrcDir: filepath.Join(dir, "src")}
+
+	config, err := readBookConfig(filepath.Join(dir, BookConfigName))
+	if err != nil {
+		return nil, err
+	}
+	book.Title = config["book.title"]
+	if src := config["book.src"]; src != "" {
+		book.SrcDir = filepath.Join(dir, src)
+	}
+
+	summary, err := os.Open(filepath.Join(book.SrcDir, "SUMMARY.md"))
+	if err != nil {
+		return nil, err
+	}
+	defer summary.Close()
+
+	book.Chapters, err = parseSummary(bufio.NewScanner(summary))
+	if err != nil {
+		return nil, fmt.Errorf("parse SUMMARY.md: %w", err)
+	}
+	return book, nil
+}
+
+// readBookConfig reads the string values of a book.toml into a map keyed by
+// "table.key", e.g. "book.title". Only the flat subset of TOML used by
+// book.toml files is understood.
+func readBookConfig(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	config := map[string]string{}
+	table := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			table = strings.Trim(line, "[] ")
+			continue
+		}
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		val = strings.TrimSpace(val)
+		if unquoted, err := strconv.Unquote(val); err == nil {
+			val = unquoted
+		} else {
+			val = strings.Trim(val, `'`)
+		}
+		config[table+"."+strings.TrimSpace(key)] = val
+	}
+	return config, scanner.Err()
+}
+
+// parseSummary reads the chapter list of a SUMMARY.md. Links outside the
+// bulleted list are unnumbered prefix or suffix chapters; list items are
+// numbered by their nesting. Draft chapters with an empty link still take
+// a number but are not returned.
+func parseSummary(scanner *bufio.Scanner) ([]BookChapter, error) {
+	var chapters []BookChapter
+	var counters []int
+	var indents []int // indentation of each open nesting level
+	var parents []int // chapter index at each open nesting level
+
+	for scanner.Scan() {
+		line := strings.ReplaceAll(scanner.Text(), "\t", "    ")
+		trimmed := strings.TrimSpace(line)
+		m := summaryLink.FindStringSubmatch(trimmed)
+		if m == nil {
+			continue // title, part headers, separators
+		}
+		title, target := m[1], strings.TrimSpace(m[2])
+
+		isItem := strings.HasPrefix(trimmed, "- ") || strings.HasPrefix(trimmed, "* ")
+		if !isItem {
+			if target != "" {
+				chapters = append(chapters, BookChapter{Title: title, Path: target, Parent: -1})
+			}
+			continue
+		}
+
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		for len(indents) > 0 && indent < indents[len(indents)-1] {
+			indents, parents = indents[:len(indents)-1], parents[:len(parents)-1]
+		}
+		if len(indents) == 0 || indent > indents[len(indents)-1] {
+			indents = append(indents, indent)
+			parents = append(parents, -1)
+		}
+		depth := len(indents) - 1
+
+		if len(counters) > depth+1 {
+			counters = counters[:depth+1]
+		}
+		for len(counters) < depth+1 {
+			counters = append(counters, 0)
+		}
+		counters[depth]++
+		number := make([]string, depth+1)
+		for i := range number {
+			number[i] = strconv.Itoa(counters[i])
+		}
+
+		parent := -1
+		if depth > 0 {
+			parent = parents[depth-1]
+		}
+		parents[depth] = -1
+		if target == "" {
+			continue
+		}
+		parents[depth] = len(chapters)
+		chapters = append(chapters, BookChapter{
+			Title:  title,
+			Path:   target,
+			Number: strings.Join(number, "."),
+			Parent: parent,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(chapters) == 0 {
+		return nil, errors.New("no chapters found")
+	}
+	return chapters, nil
+}
+
+// ChapterPath returns the file of chapter i on disk.
+func (b *Book) ChapterPath(i int) string {
+	return filepath.Join(b.SrcDir, filepath.FromSlash(b.Chapters[i].Path))
+}
+
+// ParseChapter reads chapter i and builds the same Document the HTML path
+// produces, numbered and ordered by its place in SUMMARY.md.
+func (b *Book) ParseChapter(i int, opts Options) (*Document, error) {
+	ch := b.Chapters[i]
+	path := b.ChapterPath(i)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	src, err := expandIncludes(string(data), filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ch.Path, err)
+	}
+
+	doc := &Document{
+		Source:   ch.Path,
+		Title:    ch.Title,
+		Book:     b.Title,
+		Number:   ch.Number,
+		Order:    i + 1,
+		Sections: markdownSections(src, ch.Path, opts),
+	}
+	if ch.Parent >= 0 {
+		doc.Parent = b.Chapters[ch.Parent].Path
+	}
+	return doc, nil
+}
+
+// expandIncludes resolves mdBook {{#include}} and {{#rustdoc_include}}
+// directives relative to dir. Both forms accept a line range
+// (file.rs:3:7) or an anchor name (file.rs:here); ANCHOR marker lines are
+// removed from the included text.
+func expandIncludes(src, dir string) (string, error) {
+	var firstErr error
+	out := includeDir.ReplaceAllStringFunc(src, func(directive string) string {
+		m := includeDir.FindStringSubmatch(directive)
+		target, spec, _ := strings.Cut(m[2], ":")
+
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(target)))
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return directive
+		}
+		lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+		return strings.Join(stripAnchors(selectLines(lines, spec)), "\n")
+	})
+	return out, firstErr
+}
+
+// selectLines applies an include spec: "" for the whole file, "n" for a
+// single line, "a:b", "a:" or ":b" for a 1-based line range, or an anchor.
+func selectLines(lines []string, spec string) []string {
+	if spec == "" {
+		return lines
+	}
+
+	from, to, isRange := strings.Cut(spec, ":")
+	start, errStart := strconv.Atoi(from)
+	if isRange || errStart == nil {
+		if errStart != nil {
+			start = 1
+		}
+		end, err := strconv.Atoi(to)
+		if err != nil || !isRange {
+			end = len(lines)
+			if !isRange {
+				end = start
+			}
+		}
+		start, end = max(start, 1), min(end, len(lines))
+		if start > end {
+			return nil
+		}
+		return lines[start-1 : end]
+	}
+
+	var selected []string
+	inside := false
+	for _, line := range lines {
+		switch {
+		case strings.Contains(line, "ANCHOR: "+spec):
+			inside = true
+		case strings.Contains(line, "ANCHOR_END: "+spec):
+			inside = false
+		case inside:
+			selected = append(selected, line)
+		}
+	}
+	return selected
+}
+
+func stripAnchors(lines []string) []string {
+	var kept []string
+	for _, line := range lines {
+		if !strings.Contains(line, "ANCHOR: ") && !strings.Contains(line, "ANCHOR_END: ") {
+			kept = append(kept, line)
+		}
+	}
+	return kept
+}
+
+// markdownSections splits Markdown source at its ATX headings. Code fences
+// are normalized the way the HTML path renders them: the info string is
+// reduced to the language and hidden "# " lines in Rust code are dropped
+// unless opts.KeepBoring is set. The Rust Book's <Listing> tags become
+// listing metadata and a caption paragraph. Links are resolved against the
+// chapter path source; reference definitions are dropped from the body.
+// opts.Normalization applies to headings, prose and captions, not to code
+// fences, as on the HTML path.
+func markdownSections(src, source string, opts Options) []Section {
+	nz := opts.Normalization
+	var sections []Section
+	current := &Section{}
+	var body []string
+	ids := map[string]int{}
+	defs := markdownLinkDefs(src)
+
+	finish := func() {
+		current.Body = collapseBlankLines(strings.TrimSpace(strings.Join(body, "\n")))
+		if current.Heading != "" || current.Body != "" {
+			sections = append(sections, *current)
+		}
+		body = nil
+	}
+
+	var fence, lang string
+	var code []string
+	var listing *Listing
+
+	for _, line := range strings.Split(src, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+				meta := listing
+				if meta == nil {
+					meta = &Listing{}
+				}
+				meta.Language, meta.Code = lang, strings.Join(code, "\n")
+				current.Listings = append(current.Listings, *meta)
+
+				info := lang
+				if meta.FileName != "" {
+					info += ` title="` + meta.FileName + `"`
+				}
+				body = append(body, fenceCode(meta.Code, info))
+				fence, code = "", nil
+				continue
+			}
+			if lang == "rust" && !opts.KeepBoring && isHiddenRustLine(line) {
+				continue
+			}
+			if lang == "rust" && strings.HasPrefix(strings.TrimLeft(line, " "), "##") {
+				line = strings.Replace(line, "##", "#", 1)
+			}
+			code = append(code, line)
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			fence = trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, trimmed[:1]))]
+			info, _, _ := strings.Cut(strings.TrimSpace(trimmed[len(fence):]), ",")
+			lang = ""
+			if fields := strings.Fields(info); len(fields) > 0 {
+				lang = fields[0]
+			}
+			continue
+		case strings.HasPrefix(trimmed, "<Listing"):
+			listing = &Listing{}
+			for _, kv := range htmlAttr.FindAllStringSubmatch(trimmed, -1) {
+				switch kv[1] {
+				case "number":
+					listing.ID = "listing-" + kv[2]
+					listing.Caption = "Listing " + kv[2]
+				case "file-name":
+					listing.FileName = kv[2]
+				case "caption":
+					listing.Caption += ": " + nz.Apply(kv[2])
+				}
+			}
+			continue
+		case trimmed == "</Listing>":
+			if listing != nil && listing.Caption != "" {
+				body = append(body, "", listing.Caption, "")
+			}
+			listing = nil
+			continue
+		}
+
+		if m := atxHeading.FindStringSubmatch(line); m != nil {
+			finish()
+			text := m[2]
+			anchor := ""
+			if id := headingID.FindStringSubmatch(text); id != nil {
+				anchor = id[1]
+				text = strings.TrimSpace(text[:len(text)-len(id[0])])
+			} else {
+				anchor = normalizeID(text)
+				if n := ids[anchor]; n > 0 {
+					ids[anchor] = n + 1
+					anchor = fmt.Sprintf("%s-%d", anchor, n)
+				} else {
+					ids[anchor] = 1
+				}
+			}
+			current = &Section{Heading: nz.Apply(text), Anchor: anchor, Level: len(m[1])}
+			continue
+		}
+
+		// reference definitions are not rendered
+		if mdLinkDef.MatchString(line) {
+			continue
+		}
+		line = nz.Apply(line)
+		current.Links = append(current.Links, markdownLinks(line, source, defs)...)
+		body = append(body, line)
+	}
+	finish()
+
+	return sections
+}
+
+// isHiddenRustLine reports whether a Rust code line is hidden by rustdoc
+// and mdBook: "# " followed by code, or a lone "#".
+func isHiddenRustLine(line string) bool {
+	t := strings.TrimLeft(line, " ")
+	return t == "#" || strings.HasPrefix(t, "# ")
+}
+
+// normalizeID turns heading text into the anchor mdBook generates for it.
+func normalizeID(text string) string {
+	text = inlineLink.ReplaceAllString(text, "$1")
+	var sb strings.Builder
+	for _, r := range text {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-':
+			sb.WriteRune(unicode.ToLower(r))
+		case unicode.IsSpace(r):
+			sb.WriteByte('-')
+		}
+	}
+	return sb.String()
+}
+
+func collapseBlankLines(s string) string {
+	for strings.Contains(s, "\n\n\n") {
+		s = strings.ReplaceAll(s, "\n\n\n", "\n\n")
+	}
+	return s
+}
@@ -0,0 +1,124 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/text/unicode/norm"
+)
+
+// UnicodeForm selects the Unicode normalization form applied to text.
+type UnicodeForm string
+
+const (
+	// UnicodeNone keeps text as it appears in the page.
+	UnicodeNone UnicodeForm = ""
+	// UnicodeNFC composes characters, so "e" followed by a combining
+	// acute accent and "é" compare equal.
+	UnicodeNFC UnicodeForm = "nfc"
+	// UnicodeNFKC composes characters and also replaces compatibility
+	// characters: ligatures, full-width forms, "…" becomes "...".
+	UnicodeNFKC UnicodeForm = "nfkc"
+)
+
+// ParseUnicodeForm validates a normalization form given on the command line.
+func ParseUnicodeForm(s string) (UnicodeForm, error) {
+	switch UnicodeForm(strings.ToLower(s)) {
+	case UnicodeNone, "none":
+		return UnicodeNone, nil
+	case UnicodeNFC, UnicodeNFKC:
+		return UnicodeForm(strings.ToLower(s)), nil
+	}
+	return "", fmt.Errorf("unknown unicode form %q (want none, nfc or nfkc)", s)
+}
+
+// Normalization folds the typographic variants of text, so that exact and
+// keyword matches do not depend on how a page was typeset. Entities are
+// decoded by the HTML parser, so "&rsquo;" and "&nbsp;" are folded like
+// the characters they stand for. Code listings (<pre>) are kept verbatim.
+// The zero value changes nothing.
+type Normalization struct {
+	// Form is the Unicode normalization form, applied first.
+	Form UnicodeForm `json:"form,omitempty"`
+	// FoldPunctuation replaces curly quotes, primes, dashes and the
+	// ellipsis with their ASCII counterparts.
+	FoldPunctuation bool `json:"fold_punctuation,omitempty"`
+	// CollapseSpaces replaces non-breaking and other Unicode spaces with
+	// a plain space and drops zero-width spaces and soft hyphens.
+	CollapseSpaces bool `json:"collapse_spaces,omitempty"`
+}
+
+var punctuationFolder = strings.NewReplacer(
+	"\u2018", "'", "\u2019", "'", "\u201a", "'", "\u201b", "'", "\u2032", "'",
+	"\u201c", `"`, "\u201d", `"`, "\u201e", `"`, "\u201f", `"`, "\u2033", `"`,
+	"\u2010", "-", "\u2011", "-", "\u2012", "-", "\u2013", "-", "\u2014", "-",
+	"\u2015", "-", "\u2212", "-",
+	"\u2026", "...",
+)
+
+// non-breaking and fixed-width spaces become a space; zero-width spaces,
+// word joiners, byte order marks and soft hyphens are dropped
+var spaceFolder = strings.NewReplacer(
+	"\u00a0", " ", "\u2000", " ", "\u2001", " ", "\u2002", " ", "\u2003", " ",
+	"\u2004", " ", "\u2005", " ", "\u2006", " ", "\u2007", " ", "\u2008", " ",
+	"\u2009", " ", "\u200a", " ", "\u202f", " ", "\u205f", " ", "\u3000", " ",
+	"\u200b", "", "\u2060", "", "\ufeff", "", "\u00ad", "",
+)
+
+// IsZero reports whether n leaves text unchanged.
+func (n Normalization) IsZero() bool {
+	return n == Normalization{}
+}
+
+// Apply returns s normalized. Search applies the normalization recorded in
+// the parse manifest to queries, so they match the indexed text.
+func (n Normalization) Apply(s string) string {
+	switch n.Form {
+	case UnicodeNFC:
+		s = norm.NFC.String(s)
+	case UnicodeNFKC:
+		s = norm.NFKC.String(s)
+	}
+	if n.FoldPunctuation {
+		s = punctuationFolder.Replace(s)
+	}
+	if n.CollapseSpaces {
+		s = spaceFolder.Replace(s)
+	}
+	return s
+}
+
+// parseHTML parses a page and applies opts.Normalization to its text.
+func parseHTML(r io.Reader, opts Options) (*html.Node, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.Normalization.IsZero() {
+		normalizeTree(doc, opts.Normalization)
+	}
+	return doc, nil
+}
+
+// normalizeTree normalizes the text nodes and image alt text under n,
+// leaving code listings alone.
+func normalizeTree(n *html.Node, nz Normalization) {
+	switch {
+	case n.Type == html.TextNode:
+		n.Data = nz.Apply(n.Data)
+		return
+	case n.Type == html.ElementNode && n.Data == "pre":
+		return
+	case n.Type == html.ElementNode && n.Data == "img":
+		for i, a := range n.Attr {
+			if a.Key == "alt" {
+				n.Attr[i].Val = nz.Apply(a.Val)
+			}
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		normalizeTree(c, nz)
+	}
+}
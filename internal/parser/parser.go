@@ -3,20 +3,112 @@ package parser
 
 import (
 	"bufio"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"golang.org/x/net/html"
 )
 
+// Format selects how extracted content is rendered.
+type Format string
+
+const (
+	// FormatText flattens the page into a single whitespace-joined line,
+	// except for tables, which become tab-separated rows.
+	FormatText Format = "text"
+	// FormatMarkdown keeps headings, paragraphs, lists and code as Markdown.
+	FormatMarkdown Format = "markdown"
+	// FormatJSONL renders the page as a single-line JSON Document.
+	FormatJSONL Format = "jsonl"
+)
+
+// ParseFormat validates a format name given on the command line.
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case "", FormatText:
+		return FormatText, nil
+	case FormatMarkdown, FormatJSONL:
+		return Format(s), nil
+	}
+	return "", fmt.Errorf("unknown format %q (want text, markdown or jsonl)", s)
+}
+
+// Profile selects the conventions used to find and structure the content
+// of a page.
+type Profile string
+
+const (
+	// ProfileAuto uses the rustdoc profile for pages generated by rustdoc
+	// and the mdBook profile for everything else.
+	ProfileAuto Profile = "auto"
+	// ProfileMdBook extracts the content root with the content and exclude
+	// selectors, removing mdBook's page chrome by default.
+	ProfileMdBook Profile = "mdbook"
+	// ProfileRustdoc extracts the documented item of rustdoc pages: its
+	// path, kind, signature, docs and examples, and its members.
+	ProfileRustdoc Profile = "rustdoc"
+	// ProfileGeneric extracts the content root like ProfileMdBook, but
+	// removes no page chrome unless exclude selectors are given.
+	ProfileGeneric Profile = "generic"
+)
+
+// ParseProfile validates a profile name given on the command line.
+func ParseProfile(s string) (Profile, error) {
+	switch Profile(s) {
+	case "", ProfileAuto:
+		return ProfileAuto, nil
+	case ProfileMdBook, ProfileRustdoc, ProfileGeneric:
+		return Profile(s), nil
+	}
+	return "", fmt.Errorf("unknown profile %q (want auto, mdbook, rustdoc or generic)", s)
+}
+
+// Options controls how an HTML page is converted.
+type Options struct {
+	// Format selects the output rendering, FormatText when empty.
+	Format Format
+	// Profile selects the page conventions, ProfileAuto when empty.
+	Profile Profile
+	// Unbuffered reads the input file without a bufio.Reader.
+	Unbuffered bool
+	// KeepBoring keeps the lines mdBook hides in code listings
+	// (<span class="boring">), which are dropped by default.
+	KeepBoring bool
+	// ContentSelectors locate the article body, first match wins.
+	// Nil means DefaultContentSelectors.
+	ContentSelectors []string
+	// ExcludeSelectors match page chrome removed before extraction.
+	// Nil means DefaultExcludeSelectors; an empty slice removes nothing.
+	ExcludeSelectors []string
+	// Boilerplate lists the blocks repeated across the corpus that are
+	// removed from the content. Nil removes nothing.
+	Boilerplate *Boilerplate
+	// Normalization folds Unicode forms, typographic punctuation and
+	// special spaces in the extracted text.
+	Normalization Normalization
+}
+
+// textExtractor collects the plain text of a page. Prose is normalized
+// into whitespace-joined runs; tables are kept as tab-separated rows on
+// lines of their own.
+type textExtractor struct {
+	keepBoring bool
+	prose      strings.Builder
+	parts      []string
+}
+
 // recursively walk the HTML node tree
-// and collect text nodes while ignoring scripts/styles.
-func extractText(n *html.Node, sb *strings.Builder) {
+// and collect text nodes while ignoring scripts/styles
+// and, unless keepBoring is set, hidden mdBook code lines.
+func (e *textExtractor) extract(n *html.Node) {
 	if n.Type == html.TextNode {
 		text := strings.TrimSpace(n.Data)
 		if text != "" {
-			sb.WriteString(text)
-			sb.WriteString(" ")
+			e.prose.WriteString(text)
+			e.prose.WriteString(" ")
 		}
 	}
 
@@ -25,48 +117,139 @@ func extractText(n *html.Node, sb *strings.Builder) {
 		return
 	}
 
+	if n.Type == html.ElementNode && !e.keepBoring && isBoring(n) {
+		return
+	}
+
+	// images contribute their alt text
+	if n.Type == html.ElementNode && n.Data == "img" {
+		if alt := strings.TrimSpace(attr(n, "alt")); alt != "" {
+			e.prose.WriteString(alt)
+			e.prose.WriteString(" ")
+		}
+	}
+
+	if n.Type == html.ElementNode && n.Data == "table" {
+		e.flushProse()
+		if table := buildTable(n, e.cellText).tsv(); table != "" {
+			e.parts = append(e.parts, table)
+		}
+		return
+	}
+
 	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		extractText(c, sb)
+		e.extract(c)
 	}
 }
 
-func RemoveHTMLTagsFromFile(inputPath string) (string, error) {
-	file, err := os.Open(inputPath)
+func (e *textExtractor) cellText(n *html.Node) string {
+	cell := &textExtractor{keepBoring: e.keepBoring}
+	cell.extract(n)
+	return strings.Join(strings.Fields(cell.text()), " ")
+}
+
+// normalize whitespace of the prose collected since the last table
+func (e *textExtractor) flushProse() {
+	if text := strings.Join(strings.Fields(e.prose.String()), " "); text != "" {
+		e.parts = append(e.parts, text)
+	}
+	e.prose.Reset()
+}
+
+// text returns the prose runs and tables separated by newlines.
+func (e *textExtractor) text() string {
+	e.flushProse()
+	return strings.Join(e.parts, "\n")
+}
+
+// Parse reads an HTML document from r and renders it according to opts.
+// Redirect stubs yield a *RedirectError instead of text.
+func Parse(r io.Reader, opts Options) (string, error) {
+	return parse(r, "", opts)
+}
+
+func parse(r io.Reader, name string, opts Options) (string, error) {
+	doc, err := parseHTML(r, opts)
 	if err != nil {
 		return "", err
 	}
-	defer file.Close()
 
-	reader := bufio.NewReader(file)
+	if opts.Format == FormatMarkdown || opts.Format == FormatJSONL || usesRustdoc(doc, opts) {
+		d, err := buildDocument(doc, name, opts)
+		if err != nil {
+			return "", err
+		}
+		return d.Render(opts.Format)
+	}
+
+	if target, ok := redirectTarget(doc); ok {
+		return "", &RedirectError{Target: target}
+	}
 
-	doc, err := html.Parse(reader)
+	root, err := selectContent(doc, opts)
 	if err != nil {
 		return "", err
 	}
 
-	var sb strings.Builder
-	extractText(doc, &sb)
+	e := &textExtractor{keepBoring: opts.KeepBoring}
+	e.extract(root)
+	return e.text(), nil
+}
+
+// selectContent strips page chrome from doc and returns the node holding
+// the article body.
+func selectContent(doc *html.Node, opts Options) (*html.Node, error) {
+	content, exclude, err := compileSelectors(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	removeMatching(doc, exclude)
+	root := contentRoot(doc, content)
+	opts.Boilerplate.remove(root)
+	return root, nil
+}
+
+// compileSelectors parses the content and exclude selectors of opts,
+// falling back to the defaults.
+func compileSelectors(opts Options) (content, exclude []selector, err error) {
+	contentList := opts.ContentSelectors
+	if contentList == nil {
+		contentList = DefaultContentSelectors
+	}
+	excludeList := opts.ExcludeSelectors
+	if excludeList == nil && opts.Profile != ProfileGeneric {
+		excludeList = DefaultExcludeSelectors
+	}
 
-	text := strings.Join(strings.Fields(sb.String()), " ")
-	return text, nil
+	if content, err = parseSelectors(contentList); err != nil {
+		return nil, nil, err
+	}
+	if exclude, err = parseSelectors(excludeList); err != nil {
+		return nil, nil, err
+	}
+	return content, exclude, nil
 }
 
-func RemoveHTMLTagsFromFileUnbuffered(inputPath string) (string, error) {
+// ParseFile opens inputPath and renders it according to opts.
+func ParseFile(inputPath string, opts Options) (string, error) {
 	file, err := os.Open(inputPath)
 	if err != nil {
 		return "", err
 	}
 	defer file.Close()
 
-	doc, err := html.Parse(file)
-	if err != nil {
-		return "", err
+	name := filepath.Base(inputPath)
+	if opts.Unbuffered {
+		return parse(file, name, opts)
 	}
+	return parse(bufio.NewReader(file), name, opts)
+}
 
-	var sb strings.Builder
-	extractText(doc, &sb)
+func RemoveHTMLTagsFromFile(inputPath string) (string, error) {
+	return ParseFile(inputPath, Options{})
+}
 
-	// normalize whitespace
-	text := strings.Join(strings.Fields(sb.String()), " ")
-	return text, nil
+func RemoveHTMLTagsFromFileUnbuffered(inputPath string) (string, error) {
+	return ParseFile(inputPath, Options{Unbuffered: true})
 }
@@ -1,8 +1,15 @@
 package parser_test
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -59,3 +66,985 @@ func TestRemoveHTMLTagsFromFile(t *testing.T) {
 		}
 	}
 }
+
+const StructuredHTML = `<html><body>
+<h2 id="ownership-rules"><a class="header" href="#ownership-rules">Ownership Rules</a></h2>
+<p>First, let’s take a look at the
+ownership rules:</p>
+<ul>
+<li>Each value in Rust has an <em>owner</em>.</li>
+<li>There can only be one owner at a time.</li>
+</ul>
+<ol>
+<li>Call <code>drop</code> manually.</li>
+<li>Let the value go out of scope.</li>
+</ol>
+<p>The <code>String</code> type is a great example.</p>
+</body></html>`
+
+func TestParseMarkdown(t *testing.T) {
+	output, err := parser.Parse(strings.NewReader(StructuredHTML), parser.Options{Format: parser.FormatMarkdown})
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	expected := "## Ownership Rules\n\n" +
+		"First, let’s take a look at the ownership rules:\n\n" +
+		"- Each value in Rust has an *owner*.\n" +
+		"- There can only be one owner at a time.\n\n" +
+		"1. Call `drop` manually.\n" +
+		"2. Let the value go out of scope.\n\n" +
+		"The `String` type is a great example."
+
+	if output != expected {
+		t.Fatalf("unexpected markdown:\n got: %q\nwant: %q", output, expected)
+	}
+}
+
+const ListingHTML = `<figure class="listing" id="listing-4-1">
+<span class="file-name">Filename: src/main.rs</span>
+<pre><pre class="playground"><code class="language-rust edition2024"><span class="boring">fn main() {
+</span>    let s = "hello";
+
+    println!("{s}");
+<span class="boring">}</span></code></pre></pre>
+<figcaption><a href="#listing-4-1">Listing 4-1</a>: A variable and the scope in which it is valid</figcaption>
+</figure>`
+
+func TestParseMarkdownListing(t *testing.T) {
+	output, err := parser.Parse(strings.NewReader(ListingHTML), parser.Options{Format: parser.FormatMarkdown})
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	expected := "```rust title=\"src/main.rs\"\n" +
+		"    let s = \"hello\";\n\n" +
+		"    println!(\"{s}\");\n" +
+		"```\n\n" +
+		"Listing 4-1: A variable and the scope in which it is valid"
+
+	if output != expected {
+		t.Fatalf("unexpected markdown:\n got: %q\nwant: %q", output, expected)
+	}
+
+	output, err = parser.Parse(strings.NewReader(ListingHTML), parser.Options{Format: parser.FormatMarkdown, KeepBoring: true})
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if !strings.Contains(output, "```rust title=\"src/main.rs\"\nfn main() {\n") {
+		t.Fatalf("expected boring lines to be kept: %q", output)
+	}
+}
+
+const ChromeHTML = `<html><head><title>What is Ownership? - The Rust Programming Language</title></head>
+<body>
+<nav id="sidebar" class="sidebar"><ol><li>1. Getting Started</li></ol></nav>
+<div id="menu-bar" class="menu-bar"><ul class="theme-popup"><li>Light</li><li>Navy</li></ul></div>
+<form id="searchbar-outer" class="searchbar-outer"><input type="search"></form>
+<div id="content" class="content">
+<main><h2 id="what-is-ownership">What Is Ownership?</h2><p>Ownership is a set of rules.</p></main>
+<nav class="nav-wrapper"><a class="mobile-nav-chapters previous" href="ch04-00.html">Previous chapter</a></nav>
+</div>
+<nav class="nav-wide-wrapper"><a class="nav-chapters next" href="ch04-02.html">Next chapter</a></nav>
+</body></html>`
+
+const TableHTML = `<main><p>Operators:</p>
+<table><thead><tr><th>Operator</th><th>Example</th><th>Overloadable?</th></tr></thead>
+<tbody>
+<tr><td><code>?</code></td><td><code>expr?</code></td><td>Error propagation</td></tr>
+<tr><td colspan="2">a | b</td><td><code>BitOr</code></td></tr>
+</tbody></table>
+<p>Done.</p></main>`
+
+func TestParseTable(t *testing.T) {
+	markdown, err := parser.Parse(strings.NewReader(TableHTML), parser.Options{Format: parser.FormatMarkdown})
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	wantMarkdown := "Operators:\n\n" +
+		"| Operator | Example | Overloadable? |\n" +
+		"| --- | --- | --- |\n" +
+		"| `?` | `expr?` | Error propagation |\n" +
+		"| a \\| b |  | `BitOr` |\n\n" +
+		"Done."
+	if markdown != wantMarkdown {
+		t.Fatalf("unexpected markdown:\n%s", markdown)
+	}
+
+	text, err := parser.Parse(strings.NewReader(TableHTML), parser.Options{})
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	wantText := "Operators:\n" +
+		"Operator\tExample\tOverloadable?\n" +
+		"?\texpr?\tError propagation\n" +
+		"a | b\t\tBitOr\n" +
+		"Done."
+	if text != wantText {
+		t.Fatalf("unexpected text:\n%q", text)
+	}
+
+	doc, err := parser.ParseDocument(strings.NewReader(TableHTML), "ops.html", parser.Options{})
+	if err != nil {
+		t.Fatalf("failed to parse document: %v", err)
+	}
+	if got := doc.Text(); got != wantText {
+		t.Fatalf("expected document text to match text output, got:\n%q", got)
+	}
+}
+
+func TestDocumentTextMatchesParse(t *testing.T) {
+	for name, input := range map[string]string{
+		"input":     InputHTML,
+		"structure": StructuredHTML,
+		"table":     TableHTML,
+		"figure":    FigureHTML,
+		"document":  DocumentHTML,
+	} {
+		want, err := parser.Parse(strings.NewReader(input), parser.Options{})
+		if err != nil {
+			t.Fatalf("%s: failed to parse: %v", name, err)
+		}
+		doc, err := parser.ParseDocument(strings.NewReader(input), "page.html", parser.Options{})
+		if err != nil {
+			t.Fatalf("%s: failed to parse document: %v", name, err)
+		}
+		// the HTML extractor spaces text nodes apart, "owner ." for "owner."
+		if got := doc.Text(); strings.ReplaceAll(got, " ", "") != strings.ReplaceAll(want, " ", "") {
+			t.Errorf("%s: expected document text to match text output:\n%q\ngot:\n%q", name, want, got)
+		}
+	}
+
+	// Markdown from other sources loses its markup too
+	doc := &parser.Document{Sections: []parser.Section{{
+		Heading: "The `Drop` Trait",
+		Level:   2,
+		Body: "Call *[`drop`](https://doc.rust-lang.org/std/mem/fn.drop.html)* on **snake_case** values:\n\n" +
+			"```rust\nfn main() {\n\n    drop(x); // *not* x.drop()\n}\n```\n\n" +
+			"- one `a*b`\n- two ![a cat](cat.png)\n\n> [!NOTE]\n> A \\*literal\\* star and a * b.",
+	}}}
+	want := "The Drop Trait Call drop on snake_case values: fn main() { drop(x); // *not* x.drop() } " +
+		"one a*b two a cat A *literal* star and a * b."
+	if got := doc.Text(); got != want {
+		t.Fatalf("unexpected text:\n%q", got)
+	}
+}
+
+const FigureHTML = `<main><h2 id="moves">Moves</h2>
+<p><img alt="Two tables: s1 on the stack
+and the heap data." src="img/trpl04-01.svg" class="center" /></p>
+<p><span class="caption">Figure 4-1: Representation in memory of a <code>String</code></span></p>
+<section class="note" aria-role="note"><p>Note: Rust never copies heap data implicitly.</p></section>
+<div class="warning"><p>This code panics!</p></div>
+</main>`
+
+func TestParseTypedBlocks(t *testing.T) {
+	doc, err := parser.ParseDocument(strings.NewReader(FigureHTML), "ch04-01-what-is-ownership.html", parser.Options{})
+	if err != nil {
+		t.Fatalf("failed to parse document: %v", err)
+	}
+	if len(doc.Sections) != 1 {
+		t.Fatalf("expected 1 section, got %+v", doc.Sections)
+	}
+
+	blocks := doc.Sections[0].Blocks
+	if len(blocks) != 3 {
+		t.Fatalf("expected figure, note and warning, got %+v", blocks)
+	}
+	fig := blocks[0]
+	if fig.Kind != parser.BlockFigure || fig.Label != "Figure 4-1" || fig.Src != "img/trpl04-01.svg" ||
+		fig.Alt != "Two tables: s1 on the stack and the heap data." {
+		t.Fatalf("unexpected figure: %+v", fig)
+	}
+	if blocks[1].Kind != parser.BlockNote || blocks[2].Kind != parser.BlockWarning {
+		t.Fatalf("unexpected callouts: %+v", blocks[1:])
+	}
+
+	want := "![Two tables: s1 on the stack and the heap data.](img/trpl04-01.svg)\n\n" +
+		"Figure 4-1: Representation in memory of a `String`\n\n" +
+		"> [!NOTE]\n> Note: Rust never copies heap data implicitly.\n\n" +
+		"> [!WARNING]\n> This code panics!"
+	body := doc.Sections[0].Body
+	if body != want {
+		t.Fatalf("unexpected body:\n%s", body)
+	}
+
+	parts := strings.Split(body, "\n\n")
+	origins := []string{
+		parser.BlockOrigin(strings.Join(parts[:2], "\n\n")),
+		parser.BlockOrigin(parts[2]),
+		parser.BlockOrigin(parts[3]),
+		parser.BlockOrigin("plain prose"),
+	}
+	if got := strings.Join(origins, ","); got != "figure 4-1,a note,a warning," {
+		t.Fatalf("unexpected origins %q", got)
+	}
+
+	text, err := parser.Parse(strings.NewReader(FigureHTML), parser.Options{})
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if !strings.Contains(text, "Two tables: s1 on the stack and the heap data. Figure 4-1") {
+		t.Fatalf("expected alt text in text output, got %q", text)
+	}
+}
+
+const LinksHTML = `<main>
+<h2 id="references"><a class="header" href="#references">References</a></h2>
+<p>See <a href="ch04-01-what-is-ownership.html#the-stack-and-the-heap">The Stack and the Heap</a>,
+<a href="#listing-4-5">Listing 4-5</a>, <a href="../std/index.html">std</a>
+and <a href="https://doc.rust-lang.org/">the docs</a>.</p>
+</main>`
+
+func TestParseDocumentLinks(t *testing.T) {
+	doc, err := parser.ParseDocument(strings.NewReader(LinksHTML), "ch04-02-references-and-borrowing.html", parser.Options{})
+	if err != nil {
+		t.Fatalf("failed to parse document: %v", err)
+	}
+
+	links := doc.Sections[0].Links
+	if len(links) != 2 {
+		t.Fatalf("expected 2 internal links, got %+v", links)
+	}
+	if links[0] != (parser.Link{Target: "ch04-01-what-is-ownership.html", Anchor: "the-stack-and-the-heap", Text: "The Stack and the Heap"}) {
+		t.Fatalf("unexpected link: %+v", links[0])
+	}
+	if links[1].Target != "ch04-02-references-and-borrowing.html" || links[1].Anchor != "listing-4-5" {
+		t.Fatalf("expected same-page link, got %+v", links[1])
+	}
+
+	for _, name := range []string{"src/ch04-01-what-is-ownership.md", "ch04-01-what-is-ownership-parsed.txt", "OEBPS/ch04-01-what-is-ownership.xhtml"} {
+		if got := parser.PageName(name); got != "ch04-01-what-is-ownership.html" {
+			t.Fatalf("PageName(%q) = %q", name, got)
+		}
+	}
+}
+
+func TestParseExtractsMainContent(t *testing.T) {
+	output, err := parser.Parse(strings.NewReader(ChromeHTML), parser.Options{})
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	if output != "What Is Ownership? Ownership is a set of rules." {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+func TestParseCustomContentSelector(t *testing.T) {
+	const page = `<body><div class="post"><p>Body text.</p><div class="comments">Nice post!</div></div><footer>Footer</footer></body>`
+
+	opts := parser.Options{
+		ContentSelectors: []string{"div.post"},
+		ExcludeSelectors: []string{".comments"},
+	}
+	output, err := parser.Parse(strings.NewReader(page), opts)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if output != "Body text." {
+		t.Fatalf("unexpected output: %q", output)
+	}
+
+	opts.ContentSelectors = []string{"div > p"}
+	if _, err := parser.Parse(strings.NewReader(page), opts); err == nil {
+		t.Fatal("expected error for unsupported selector, got nil")
+	}
+}
+
+const RedirectHTML = `<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="utf-8">
+    <title>Redirecting...</title>
+    <meta http-equiv="refresh" content="0; URL=ch18-00-oop.html">
+    <link rel="canonical" href="ch18-00-oop.html">
+  </head>
+  <body>
+      <p>Redirecting to... <a href="ch18-00-oop.html">ch18-00-oop.html</a>.</p>
+  </body>
+</html>`
+
+func TestParseRedirectStub(t *testing.T) {
+	_, err := parser.Parse(strings.NewReader(RedirectHTML), parser.Options{})
+	target, ok := parser.IsRedirect(err)
+	if !ok {
+		t.Fatalf("expected redirect error, got %v", err)
+	}
+	if target != "ch18-00-oop.html" {
+		t.Fatalf("expected target ch18-00-oop.html, got %q", target)
+	}
+}
+
+func TestAliasesSaveLoadResolve(t *testing.T) {
+	path := filepath.Join(t.TempDir(), parser.AliasFileName)
+
+	aliases := parser.Aliases{
+		"ch17-00-oop.html": "ch18-00-oop.html",
+		"ch16-00-oop.html": "ch17-00-oop.html",
+	}
+	if err := aliases.Save(path); err != nil {
+		t.Fatalf("save aliases: %v", err)
+	}
+
+	loaded, err := parser.LoadAliases(path)
+	if err != nil {
+		t.Fatalf("load aliases: %v", err)
+	}
+
+	if got := loaded.Resolve("old/ch16-00-oop.html#objects"); got != "ch18-00-oop.html" {
+		t.Fatalf("expected chained alias to resolve to ch18-00-oop.html, got %q", got)
+	}
+	if got := loaded.Resolve("ch04-01-what-is-ownership.html"); got != "ch04-01-what-is-ownership.html" {
+		t.Fatalf("expected non-alias to resolve to itself, got %q", got)
+	}
+}
+
+const DocumentHTML = `<html><head><title>What is Ownership? - The Rust Programming Language</title></head>
+<body><main>
+<h2 id="what-is-ownership"><a class="header" href="#what-is-ownership">What Is Ownership?</a></h2>
+<p>Ownership is a set of rules.</p>
+<section class="note" aria-role="note">
+<h3 id="the-stack-and-the-heap"><a class="header" href="#the-stack-and-the-heap">The Stack and the Heap</a></h3>
+<p>Both the stack and the heap are parts of memory.</p>
+</section>
+<h3><a class="header" href="#variable-scope">Variable Scope</a></h3>
+<figure class="listing" id="listing-4-1">
+<pre><code class="language-rust">let s = "hello";</code></pre>
+<figcaption>Listing 4-1: A variable</figcaption>
+</figure>
+</main></body></html>`
+
+func TestParseDocument(t *testing.T) {
+	doc, err := parser.ParseDocument(strings.NewReader(DocumentHTML), "ch04-01-what-is-ownership.html", parser.Options{})
+	if err != nil {
+		t.Fatalf("failed to parse document: %v", err)
+	}
+
+	if doc.Title != "What is Ownership?" || doc.Book != "The Rust Programming Language" {
+		t.Fatalf("unexpected title %q / book %q", doc.Title, doc.Book)
+	}
+	if doc.Number != "4.1" {
+		t.Fatalf("expected number 4.1, got %q", doc.Number)
+	}
+	if len(doc.Sections) != 3 {
+		t.Fatalf("expected 3 sections, got %d: %+v", len(doc.Sections), doc.Sections)
+	}
+
+	stack := doc.Sections[1]
+	if stack.Anchor != "the-stack-and-the-heap" || stack.Level != 3 {
+		t.Fatalf("unexpected section: %+v", stack)
+	}
+	if stack.Body != "> [!NOTE]\n> Both the stack and the heap are parts of memory." {
+		t.Fatalf("unexpected section body: %q", stack.Body)
+	}
+	if len(stack.Blocks) != 1 || stack.Blocks[0].Kind != parser.BlockNote {
+		t.Fatalf("expected the note as a typed block, got %+v", stack.Blocks)
+	}
+	if got := doc.Citation(&stack); got != "Chapter 4.1 › The Stack and the Heap" {
+		t.Fatalf("unexpected citation: %q", got)
+	}
+
+	scope := doc.Sections[2]
+	if scope.Anchor != "variable-scope" {
+		t.Fatalf("expected anchor from header link, got %q", scope.Anchor)
+	}
+	if len(scope.Listings) != 1 || scope.Listings[0].ID != "listing-4-1" || scope.Listings[0].Caption != "Listing 4-1: A variable" {
+		t.Fatalf("unexpected listings: %+v", scope.Listings)
+	}
+}
+
+func TestChapterNumber(t *testing.T) {
+	cases := map[string]string{
+		"ch04-01-what-is-ownership.html":  "4.1",
+		"ch04-00-understanding-ownership": "4",
+		"ch00-00-introduction.html":       "",
+		"appendix-02-operators.html":      "B",
+		"foreword.html":                   "",
+	}
+	for name, want := range cases {
+		if got := parser.ChapterNumber(name); got != want {
+			t.Errorf("ChapterNumber(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestManifestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), parser.ManifestFileName)
+
+	empty, err := parser.LoadManifest(path)
+	if err != nil {
+		t.Fatalf("load missing manifest: %v", err)
+	}
+	if len(empty.Entries) != 0 {
+		t.Fatalf("expected empty manifest, got %d entries", len(empty.Entries))
+	}
+
+	entry := parser.ManifestEntry{
+		Source:        "corpus/raw/ch04-01-what-is-ownership.html",
+		SourceHash:    parser.HashBytes([]byte(InputHTML)),
+		ParserVersion: parser.VersionFor(parser.Options{}),
+		Output:        "ch04-01-what-is-ownership-parsed.txt",
+		OutputHash:    parser.HashBytes([]byte("Welcome")),
+	}
+	empty.Entries[entry.Source] = entry
+	if err := empty.Save(path); err != nil {
+		t.Fatalf("save manifest: %v", err)
+	}
+
+	loaded, err := parser.LoadManifest(path)
+	if err != nil {
+		t.Fatalf("load manifest: %v", err)
+	}
+	if loaded.Entries[entry.Source] != entry {
+		t.Fatalf("expected %+v, got %+v", entry, loaded.Entries[entry.Source])
+	}
+}
+
+func TestVersionForTracksOptions(t *testing.T) {
+	text := parser.VersionFor(parser.Options{})
+	if text != parser.VersionFor(parser.Options{Format: parser.FormatText}) {
+		t.Fatal("expected empty format to match text format")
+	}
+	if text == parser.VersionFor(parser.Options{Format: parser.FormatMarkdown}) {
+		t.Fatal("expected version to change with format")
+	}
+	if text == parser.VersionFor(parser.Options{KeepBoring: true}) {
+		t.Fatal("expected version to change with keep-boring")
+	}
+}
+
+const TypographyHTML = `<main><h1>Rust&rsquo;s &ldquo;Ownership&rdquo;</h1>
+<p>Ownership&nbsp;rules &mdash; three of them&hellip; ﬁrst: each value has an owner.</p>
+<p>A soft hy&shy;phen and a zero&#8203;width space.</p>
+<pre><code>let s = "Rust’s — verbatim";</code></pre></main>`
+
+func TestNormalization(t *testing.T) {
+	all := parser.Normalization{Form: parser.UnicodeNFKC, FoldPunctuation: true, CollapseSpaces: true}
+
+	for _, tc := range []struct {
+		norm parser.Normalization
+		in   string
+		want string
+	}{
+		{parser.Normalization{}, "Rust’s\u00a0book", "Rust’s\u00a0book"},
+		{parser.Normalization{Form: parser.UnicodeNFC}, "cafe\u0301", "café"},
+		{parser.Normalization{Form: parser.UnicodeNFC}, "ﬁle", "ﬁle"},
+		{parser.Normalization{Form: parser.UnicodeNFKC}, "ﬁle…", "file..."},
+		{parser.Normalization{FoldPunctuation: true}, "“Rust’s” – 2—3", `"Rust's" - 2-3`},
+		{parser.Normalization{CollapseSpaces: true}, "a\u00a0b\u202fc\u200bd\u00ade", "a b cde"},
+	} {
+		if got := tc.norm.Apply(tc.in); got != tc.want {
+			t.Errorf("%+v.Apply(%q) = %q, want %q", tc.norm, tc.in, got, tc.want)
+		}
+	}
+
+	got, err := parser.Parse(strings.NewReader(TypographyHTML), parser.Options{Normalization: all})
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	want := `Rust's "Ownership" Ownership rules - three of them... first: each value has an owner. ` +
+		`A soft hyphen and a zerowidth space. let s = "Rust’s — verbatim";`
+	if got != want {
+		t.Fatalf("unexpected text\nwant %q\ngot  %q", want, got)
+	}
+
+	md, err := parser.Parse(strings.NewReader(TypographyHTML), parser.Options{Format: parser.FormatMarkdown, Normalization: all})
+	if err != nil {
+		t.Fatalf("failed to parse markdown: %v", err)
+	}
+	if !strings.Contains(md, `# Rust's "Ownership"`) || !strings.Contains(md, `let s = "Rust’s — verbatim";`) {
+		t.Fatalf("expected folded heading and verbatim listing, got:\n%s", md)
+	}
+
+	// mdBook sources are normalized the same way, code fences excepted
+	root := t.TempDir()
+	writeBookFile(t, root, "book.toml", "[book]\ntitle = \"The Rust Programming Language\"\n")
+	writeBookFile(t, root, "src/SUMMARY.md", "- [Ownership](ch04-01-what-is-ownership.md)\n")
+	writeBookFile(t, root, "src/ch04-01-what-is-ownership.md", "# Rust’s “Ownership”\n\n"+
+		"Ownership rules – three of them…\n\n"+
+		"```rust\nlet s = \"Rust’s — verbatim\";\n```\n")
+	book, err := parser.LoadBook(root)
+	if err != nil {
+		t.Fatalf("failed to load book: %v", err)
+	}
+	doc, err := book.ParseChapter(0, parser.Options{Normalization: all})
+	if err != nil {
+		t.Fatalf("failed to parse chapter: %v", err)
+	}
+	want = `Rust's "Ownership" Ownership rules - three of them... let s = "Rust’s — verbatim";`
+	if got := doc.Text(); got != want {
+		t.Fatalf("unexpected mdBook text\nwant %q\ngot  %q", want, got)
+	}
+	if doc.Sections[0].Anchor != "rusts-ownership" {
+		t.Fatalf("expected the anchor of the source heading, got %q", doc.Sections[0].Anchor)
+	}
+
+	if parser.VersionFor(parser.Options{}) == parser.VersionFor(parser.Options{Normalization: all}) {
+		t.Fatal("expected version to change with normalization")
+	}
+	if _, err := parser.ParseUnicodeForm("nfd"); err == nil {
+		t.Fatal("expected an error for an unsupported unicode form")
+	}
+
+	path := filepath.Join(t.TempDir(), parser.ManifestFileName)
+	m, err := parser.LoadManifest(path)
+	if err != nil {
+		t.Fatalf("load manifest: %v", err)
+	}
+	m.Normalization = all
+	if err := m.Save(path); err != nil {
+		t.Fatalf("save manifest: %v", err)
+	}
+	loaded, err := parser.LoadManifest(path)
+	if err != nil {
+		t.Fatalf("load manifest: %v", err)
+	}
+	if loaded.Normalization != all {
+		t.Fatalf("expected %+v, got %+v", all, loaded.Normalization)
+	}
+}
+
+func boilerplatePage(body string) string {
+	return `<html><body><div class="header"><h1>My Docs</h1></div>` + body +
+		`<footer><p>Licensed under CC-BY 4.0.</p><p>Print this page</p></footer></body></html>`
+}
+
+func TestBoilerplate(t *testing.T) {
+	pages := []string{
+		boilerplatePage(`<p>First page.</p><pre><code>fn main() {}</code></pre>`),
+		boilerplatePage(`<p>Second page.</p><pre><code>fn main() {}</code></pre>`),
+		boilerplatePage(`<p>Third page.</p><p>Shared by two.</p>`),
+		`<html><body><p>Fourth page.</p><p>Shared by two.</p></body></html>`,
+	}
+
+	var counter parser.BoilerplateCounter
+	for _, page := range pages {
+		blocks, err := parser.BoilerplateBlocks(strings.NewReader(page), parser.Options{})
+		if err != nil {
+			t.Fatalf("failed to read blocks: %v", err)
+		}
+		counter.Add(blocks)
+	}
+	bp := counter.Boilerplate(0.5)
+
+	want := []parser.BoilerplateBlock{
+		{Text: "Licensed under CC-BY 4.0.", Documents: 3},
+		{Text: "My Docs", Documents: 3},
+		{Text: "Print this page", Documents: 3},
+	}
+	if bp.Documents != 4 || !reflect.DeepEqual(bp.Blocks, want) {
+		t.Fatalf("unexpected boilerplate: %+v", bp)
+	}
+
+	opts := parser.Options{Boilerplate: bp}
+	text, err := parser.Parse(strings.NewReader(pages[0]), opts)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if text != "First page. fn main() {}" {
+		t.Fatalf("expected boilerplate to be removed and code kept, got %q", text)
+	}
+
+	markdown, err := parser.Parse(strings.NewReader(pages[2]), parser.Options{Format: parser.FormatMarkdown, Boilerplate: bp})
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if markdown != "Third page.\n\nShared by two." {
+		t.Fatalf("unexpected markdown: %q", markdown)
+	}
+
+	if parser.VersionFor(opts) == parser.VersionFor(parser.Options{}) {
+		t.Fatal("expected version to change with boilerplate")
+	}
+	if err := parser.ExtractText(io.Discard, strings.NewReader(pages[0]), opts); err == nil {
+		t.Fatal("expected streaming extraction to reject boilerplate removal")
+	}
+}
+
+const RustdocHTML = `<!DOCTYPE html><html lang="en"><head><meta name="generator" content="rustdoc">
+<title>Rc in std::rc - Rust</title></head>
+<body class="rustdoc struct"><nav class="sidebar"><h2><a href="../../std/index.html">std</a></h2></nav>
+<main><div class="width-limiter"><section id="main-content" class="content">
+<div class="main-heading"><div class="rustdoc-breadcrumbs"><a href="../index.html">std</a>::<wbr><a href="index.html">rc</a></div>
+<h1>Struct <span class="struct">Rc</span><button id="copy-path" title="Copy item path to clipboard">Copy item path</button></h1>
+<span class="sub-heading"><span class="since" title="Stable since Rust version 1.0.0">1.0.0</span> · <a class="src" href="../../src/alloc/rc.rs.html#310">Source</a></span></div>
+<pre class="rust item-decl"><code>pub struct Rc&lt;T&gt;<div class="where">where
+    T: ?<a class="trait" href="../marker/trait.Sized.html">Sized</a>,</div>{ <span class="comment">/* private fields */</span> }</code></pre>
+<details class="toggle top-doc" open><summary class="hideme"><span>Expand description</span></summary><div class="docblock">
+<p>A single-threaded reference-counting pointer.</p>
+<h2 id="examples"><a class="doc-anchor" href="#examples">§</a>Examples</h2>
+<div class="example-wrap"><pre class="rust rust-example-rendered"><code><span class="kw">use </span>std::rc::Rc;</code></pre><a class="test-arrow" href="https://play.rust-lang.org/">Run</a></div>
+</div></details>
+<h2 id="implementations" class="section-header">Implementations<a href="#implementations" class="anchor">§</a></h2>
+<div id="implementations-list"><details class="toggle implementors-toggle" open><summary><section id="impl-Rc%3CT%3E" class="impl"><h3 class="code-header">impl&lt;T&gt; Rc&lt;T&gt;</h3></section></summary>
+<div class="impl-items"><details class="toggle method-toggle" open><summary><section id="method.new" class="method"><span class="rightside"><span class="since">1.0.0</span> · <a class="src" href="#">Source</a></span>
+<h4 class="code-header">pub fn <a href="#method.new" class="fn">new</a>(value: T) -&gt; Rc&lt;T&gt;</h4></section></summary>
+<div class="docblock"><p>Constructs a new <code>Rc&lt;T&gt;</code>.</p>
+<h5 id="examples-1"><a class="doc-anchor" href="#examples-1">§</a>Examples</h5>
+<div class="example-wrap"><pre class="rust rust-example-rendered"><code>let five = Rc::new(5);</code></pre></div></div></details></div></details></div>
+<h2 id="trait-implementations" class="section-header">Trait Implementations</h2>
+<div id="trait-implementations-list"><details class="toggle implementors-toggle"><summary><section id="impl-Clone-for-Rc%3CT%3E" class="impl"><h3 class="code-header">impl Clone for Rc&lt;T&gt;</h3></section></summary>
+<div class="impl-items"><details class="toggle method-toggle"><summary><section id="method.clone" class="method trait-impl"><h4 class="code-header">fn clone(&amp;self) -&gt; Self</h4></section></summary>
+<div class="docblock"><p>Makes a clone of the <code>Rc</code> pointer.</p></div></details></div></details></div>
+</section></div></main></body></html>`
+
+func TestParseRustdoc(t *testing.T) {
+	doc, err := parser.ParseDocument(strings.NewReader(RustdocHTML), "struct.Rc.html", parser.Options{})
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	wantItem := &parser.Item{
+		Path:      "std::rc::Rc",
+		Kind:      "struct",
+		Signature: "pub struct Rc<T>\nwhere\n    T: ?Sized,\n{ /* private fields */ }",
+		Since:     "1.0.0",
+	}
+	if !reflect.DeepEqual(doc.Item, wantItem) {
+		t.Fatalf("unexpected item: %+v", doc.Item)
+	}
+	if doc.Title != "std::rc::Rc" || doc.Book != "std" {
+		t.Fatalf("unexpected title %q and book %q", doc.Title, doc.Book)
+	}
+
+	var headings []string
+	for _, s := range doc.Sections {
+		headings = append(headings, s.Heading)
+	}
+	wantHeadings := []string{"std::rc::Rc", "Examples", "std::rc::Rc::new"}
+	if !reflect.DeepEqual(headings, wantHeadings) {
+		t.Fatalf("expected sections %q, got %q", wantHeadings, headings)
+	}
+
+	method := doc.Sections[2]
+	wantBody := "```rust\npub fn new(value: T) -> Rc<T>\n```\n\n" +
+		"Constructs a new `Rc<T>`.\n\n" +
+		"**Examples**\n\n" +
+		"```rust\nlet five = Rc::new(5);\n```"
+	if method.Anchor != "method.new" || method.Body != wantBody {
+		t.Fatalf("unexpected method section %q:\n%s", method.Anchor, method.Body)
+	}
+	if examples := doc.Sections[1].Listings; len(examples) != 1 || examples[0].Language != "rust" || examples[0].Code != "use std::rc::Rc;" {
+		t.Fatalf("unexpected examples: %+v", examples)
+	}
+	if md := doc.Markdown(); strings.Contains(md, "clone") || strings.Contains(md, "§") || strings.Contains(md, "Source") {
+		t.Fatalf("expected trait impls and rustdoc controls to be dropped:\n%s", md)
+	}
+
+	// the mdbook profile treats the page like any other
+	forced, err := parser.ParseDocument(strings.NewReader(RustdocHTML), "struct.Rc.html", parser.Options{Profile: parser.ProfileMdBook})
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if forced.Item != nil {
+		t.Fatalf("expected no item with the mdbook profile, got %+v", forced.Item)
+	}
+	if parser.VersionFor(parser.Options{}) == parser.VersionFor(parser.Options{Profile: parser.ProfileRustdoc}) {
+		t.Fatal("expected version to change with profile")
+	}
+
+	// rustdoc's standalone Markdown pages have no item layout
+	standalone := `<html><head><meta name="generator" content="rustdoc"></head><body class="rustdoc"><h1 class="title">Choosing your Guarantees</h1><p>This is an old link.</p></body></html>`
+	if text, err := parser.Parse(strings.NewReader(standalone), parser.Options{}); err != nil || text != "Choosing your Guarantees This is an old link." {
+		t.Fatalf("expected standalone rustdoc page to be read as text, got %q, %v", text, err)
+	}
+
+	source := `<html><head><meta name="generator" content="rustdoc"></head><body class="rustdoc src"><main><section id="main-content"><pre>fn main() {}</pre></section></main></body></html>`
+	if _, err := parser.Parse(strings.NewReader(source), parser.Options{}); !errors.Is(err, parser.ErrNoItem) {
+		t.Fatalf("expected ErrNoItem for a source view, got %v", err)
+	}
+}
+
+func writeBookFile(t *testing.T, root, name, content string) {
+	t.Helper()
+	path := filepath.Join(root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), FileWritePerm); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+}
+
+func TestLoadBookAndParseChapter(t *testing.T) {
+	root := t.TempDir()
+	writeBookFile(t, root, "book.toml", "[book]\ntitle = \"The Rust Programming Language\"\nsrc = \"src\"\n")
+	writeBookFile(t, root, "src/SUMMARY.md", `# Summary
+
+[Foreword](foreword.md)
+
+- [Understanding Ownership](ch04-00-understanding-ownership.md)
+    - [What is Ownership?](ch04-01-what-is-ownership.md)
+    - [Draft]()
+    - [The Slice Type](ch04-03-slices.md)
+`)
+	writeBookFile(t, root, "src/ch04-01-what-is-ownership.md", "## What Is Ownership?\n\n"+
+		"Ownership is a set of rules, see [Chapter 8][ch08] and [slices](ch04-03-slices.md#string-slices).\n\n"+
+		"### The Stack and the Heap\n\n"+
+		"```rust\n# fn main() {\nlet s = \"hello\";\n# }\n```\n\n"+
+		"[ch08]: ch08-00-common-collections.html\n")
+
+	if !parser.IsBook(root) {
+		t.Fatal("expected directory to be recognized as an mdBook")
+	}
+
+	book, err := parser.LoadBook(root)
+	if err != nil {
+		t.Fatalf("failed to load book: %v", err)
+	}
+
+	var numbers []string
+	for _, ch := range book.Chapters {
+		numbers = append(numbers, ch.Number)
+	}
+	if got := strings.Join(numbers, ","); got != ",1,1.1,1.3" {
+		t.Fatalf("unexpected chapter numbers %q", got)
+	}
+
+	doc, err := book.ParseChapter(2, parser.Options{})
+	if err != nil {
+		t.Fatalf("failed to parse chapter: %v", err)
+	}
+	if doc.Number != "1.1" || doc.Order != 3 || doc.Parent != "ch04-00-understanding-ownership.md" {
+		t.Fatalf("unexpected document metadata: %+v", doc)
+	}
+	if len(doc.Sections) != 2 || doc.Sections[1].Anchor != "the-stack-and-the-heap" {
+		t.Fatalf("unexpected sections: %+v", doc.Sections)
+	}
+	if links := doc.Sections[0].Links; len(links) != 2 || links[0].Target != "ch08-00-common-collections.html" || links[1].Anchor != "string-slices" {
+		t.Fatalf("unexpected links: %+v", links)
+	}
+	if body := doc.Sections[1].Body; body != "```rust\nlet s = \"hello\";\n```" {
+		t.Fatalf("expected hidden lines to be dropped, got %q", body)
+	}
+}
+
+func TestReadEPUB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.epub")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create epub: %v", err)
+	}
+	zw := zip.NewWriter(file)
+	for _, f := range []struct{ name, content string }{
+		{"mimetype", "application/epub+zip"},
+		{"META-INF/container.xml", `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`},
+		{"OEBPS/content.opf", `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" xmlns:dc="http://purl.org/dc/elements/1.1/" version="3.0">
+  <metadata><dc:title>The Rust Programming Language</dc:title></metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="c1" href="text/ch04-01-what-is-ownership.xhtml" media-type="application/xhtml+xml"/>
+    <item id="c2" href="text/ch04-02-references.xhtml" media-type="application/xhtml+xml"/>
+    <item id="css" href="style.css" media-type="text/css"/>
+  </manifest>
+  <spine><itemref idref="c2"/><itemref idref="c1"/></spine>
+</package>`},
+		{"OEBPS/text/ch04-01-what-is-ownership.xhtml", `<html xmlns="http://www.w3.org/1999/xhtml"><head><title>What Is Ownership?</title></head>
+<body><h1 id="what-is-ownership">What Is Ownership?</h1><p>Ownership is a set of rules.</p></body></html>`},
+		{"OEBPS/text/ch04-02-references.xhtml", `<html xmlns="http://www.w3.org/1999/xhtml"><head><title>References</title></head>
+<body><h1 id="references">References and Borrowing</h1><p>A reference is like a pointer.</p></body></html>`},
+	} {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatalf("failed to add %s: %v", f.name, err)
+		}
+		if _, err := w.Write([]byte(f.content)); err != nil {
+			t.Fatalf("failed to write %s: %v", f.name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+	file.Close()
+
+	book, err := parser.ReadEPUB(path)
+	if err != nil {
+		t.Fatalf("failed to read epub: %v", err)
+	}
+	if book.Title != "The Rust Programming Language" || len(book.Items) != 2 {
+		t.Fatalf("unexpected book: %q with %d items", book.Title, len(book.Items))
+	}
+	if book.Items[0].Href != "OEBPS/text/ch04-02-references.xhtml" {
+		t.Fatalf("expected spine order, got %q first", book.Items[0].Href)
+	}
+
+	doc, err := book.ParseItem(1, parser.Options{})
+	if err != nil {
+		t.Fatalf("failed to parse item: %v", err)
+	}
+	if doc.Order != 2 || doc.Number != "4.1" || doc.Book != "The Rust Programming Language" {
+		t.Fatalf("unexpected document metadata: %+v", doc)
+	}
+	if got := doc.Text(); got != "What Is Ownership? Ownership is a set of rules." {
+		t.Fatalf("unexpected text %q", got)
+	}
+}
+
+func TestReadArchive(t *testing.T) {
+	files := []struct{ name, body string }{
+		{"book/ch01.html", "<p>one</p>"},
+		{"book/img/logo.png", "png"},
+		{"book/sub/ch02.html", "<p>two</p>"},
+		{"../escape.html", "<p>out</p>"},
+	}
+	isHTML := func(name string) bool { return filepath.Ext(name) == ".html" }
+	want := []parser.ArchiveMember{
+		{Name: "book/ch01.html", Data: []byte("<p>one</p>")},
+		{Name: "book/sub/ch02.html", Data: []byte("<p>two</p>")},
+	}
+
+	dir := t.TempDir()
+
+	zipPath := filepath.Join(dir, "book.zip")
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatalf("failed to add %s: %v", f.name, err)
+		}
+		if _, err := w.Write([]byte(f.body)); err != nil {
+			t.Fatalf("failed to write %s: %v", f.name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+	if err := os.WriteFile(zipPath, zbuf.Bytes(), FileWritePerm); err != nil {
+		t.Fatalf("failed to write zip: %v", err)
+	}
+
+	tarPath := filepath.Join(dir, "book.tar.gz")
+	var tbuf bytes.Buffer
+	gz := gzip.NewWriter(&tbuf)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: "book/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+		t.Fatalf("failed to add book/: %v", err)
+	}
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(f.body))}); err != nil {
+			t.Fatalf("failed to add %s: %v", f.name, err)
+		}
+		if _, err := tw.Write([]byte(f.body)); err != nil {
+			t.Fatalf("failed to write %s: %v", f.name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close tar: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("failed to close gzip: %v", err)
+	}
+	if err := os.WriteFile(tarPath, tbuf.Bytes(), FileWritePerm); err != nil {
+		t.Fatalf("failed to write tar: %v", err)
+	}
+
+	for _, path := range []string{zipPath, tarPath} {
+		if !parser.IsArchive(path) {
+			t.Fatalf("expected %s to be an archive", filepath.Base(path))
+		}
+		got, err := parser.ReadArchive(path, isHTML)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", filepath.Base(path), err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: expected %+v, got %+v", filepath.Base(path), want, got)
+		}
+	}
+	if parser.IsArchive("ch01.html") {
+		t.Fatal("expected ch01.html not to be an archive")
+	}
+}
+
+func TestExtractTextMatchesParse(t *testing.T) {
+	for name, input := range map[string]string{
+		"input":      InputHTML,
+		"structure":  StructuredHTML,
+		"listing":    ListingHTML,
+		"chrome":     ChromeHTML,
+		"table":      TableHTML,
+		"figure":     FigureHTML,
+		"rustdoc":    RustdocHTML,
+		"typography": TypographyHTML,
+	} {
+		for _, opts := range []parser.Options{
+			{}, {KeepBoring: true}, {ContentSelectors: []string{"p"}},
+			{Normalization: parser.Normalization{Form: parser.UnicodeNFKC, FoldPunctuation: true, CollapseSpaces: true}},
+		} {
+			want, err := parser.Parse(strings.NewReader(input), opts)
+			if err != nil {
+				t.Fatalf("%s: failed to parse: %v", name, err)
+			}
+			var buf bytes.Buffer
+			if err := parser.ExtractText(&buf, strings.NewReader(input), opts); err != nil {
+				t.Fatalf("%s: failed to extract: %v", name, err)
+			}
+			if buf.String() != want {
+				t.Fatalf("%s with %+v: streamed text differs\nwant %q\ngot  %q", name, opts, want, buf.String())
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	err := parser.ExtractText(&buf, strings.NewReader(RedirectHTML), parser.Options{})
+	if target, ok := parser.IsRedirect(err); !ok || target != "ch18-00-oop.html" {
+		t.Fatalf("expected redirect to ch18-00-oop.html, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected no output for a redirect stub, got %q", buf.String())
+	}
+}
+
+// benchmarkPage is a single-page export sized like a whole book: every
+// chapter's body repeated in one <main>.
+func benchmarkPage(b *testing.B) string {
+	b.Helper()
+	var sb strings.Builder
+	sb.WriteString("<html><head><title>Book</title></head><body><nav class=\"sidebar\">toc</nav><main>")
+	for range 2000 {
+		sb.WriteString(InputHTML)
+		sb.WriteString(TableHTML)
+	}
+	sb.WriteString("</main></body></html>")
+
+	path := filepath.Join(b.TempDir(), "book.html")
+	if err := os.WriteFile(path, []byte(sb.String()), FileWritePerm); err != nil {
+		b.Fatalf("failed to write benchmark page: %v", err)
+	}
+	return path
+}
+
+func BenchmarkRemoveHTMLTagsFromFile(b *testing.B) {
+	path := benchmarkPage(b)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := parser.RemoveHTMLTagsFromFile(path); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRemoveHTMLTagsFromFileUnbuffered(b *testing.B) {
+	path := benchmarkPage(b)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := parser.RemoveHTMLTagsFromFileUnbuffered(path); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkExtractTextFile(b *testing.B) {
+	path := benchmarkPage(b)
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := parser.ExtractTextFile(io.Discard, path, parser.Options{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
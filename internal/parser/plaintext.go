@@ -0,0 +1,219 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	// block markers at the start of a line: list items, headings and the
+	// alert marker of a note or warning
+	listMarker    = regexp.MustCompile(`^(?:[-*+]|\d{1,9}[.)])(?:\s+|$)`)
+	headingMarker = regexp.MustCompile(`^#{1,6}(?:\s+|$)`)
+	alertLine     = regexp.MustCompile(`^\[!(?:NOTE|WARNING|TIP|IMPORTANT|CAUTION)\]$`)
+	thematicBreak = regexp.MustCompile(`^(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+)
+
+// markdownText flattens Markdown into the shape textExtractor gives HTML:
+// whitespace-joined prose, with tables as tab-separated rows on lines of
+// their own. Markup is dropped; code keeps its text, links their text and
+// images their alt text.
+func markdownText(md string) string {
+	var parts, prose, block []string
+	flushProse := func() {
+		if len(prose) > 0 {
+			parts = append(parts, strings.Join(prose, " "))
+			prose = nil
+		}
+	}
+	endBlock := func() {
+		if table, ok := parseMarkdownTable(strings.Join(block, "\n")); ok {
+			flushProse()
+			if tsv := table.tsv(); tsv != "" {
+				parts = append(parts, tsv)
+			}
+		} else {
+			for _, line := range block {
+				prose = append(prose, strings.Fields(plainLine(line))...)
+			}
+		}
+		block = nil
+	}
+
+	// fence is the open code fence, quoted whether it is in a blockquote
+	var fence string
+	var quoted bool
+	for _, line := range strings.Split(md, "\n") {
+		trimmed := strings.TrimSpace(stripQuote(strings.TrimSpace(line)))
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+				fence = ""
+			} else if quoted {
+				prose = append(prose, strings.Fields(stripQuote(strings.TrimSpace(line)))...)
+			} else {
+				prose = append(prose, strings.Fields(line)...)
+			}
+			continue
+		}
+		if rest := strings.TrimSpace(listMarker.ReplaceAllString(trimmed, "")); strings.HasPrefix(rest, "```") || strings.HasPrefix(rest, "~~~") {
+			endBlock()
+			fence = rest[:len(rest)-len(strings.TrimLeft(rest, rest[:1]))]
+			quoted = strings.HasPrefix(strings.TrimSpace(line), ">")
+			continue
+		}
+		if trimmed == "" {
+			endBlock()
+			continue
+		}
+		block = append(block, line)
+	}
+	endBlock()
+	flushProse()
+	return strings.Join(parts, "\n")
+}
+
+// stripQuote removes the blockquote markers from the start of line.
+func stripQuote(line string) string {
+	for strings.HasPrefix(line, ">") {
+		line = strings.TrimLeft(line[1:], " ")
+	}
+	return line
+}
+
+// plainLine returns the text of a line of Markdown prose.
+func plainLine(line string) string {
+	line = strings.TrimSpace(stripQuote(strings.TrimSpace(line)))
+	if alertLine.MatchString(line) || thematicBreak.MatchString(line) {
+		return ""
+	}
+	line = listMarker.ReplaceAllString(line, "")
+	line = headingMarker.ReplaceAllString(line, "")
+	return plainInline(line)
+}
+
+// plainInline drops inline Markdown: code span backticks, emphasis
+// markers, backslash escapes and link and image syntax. Underscores
+// inside words, as in snake_case, are kept.
+func plainInline(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]):
+			sb.WriteByte(s[i+1])
+			i += 2
+		case c == '`':
+			n := len(s[i:]) - len(strings.TrimLeft(s[i:], "`"))
+			fence := s[i : i+n]
+			end := codeSpanEnd(s[i+n:], fence)
+			if end < 0 {
+				sb.WriteString(fence)
+				i += n
+				continue
+			}
+			code := s[i+n : i+n+end]
+			if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' {
+				code = code[1 : len(code)-1]
+			}
+			sb.WriteString(code)
+			i += n + end + n
+		case c == '!' && strings.HasPrefix(s[i+1:], "["):
+			if text, n, ok := linkText(s[i+1:]); ok {
+				sb.WriteString(plainInline(text))
+				i += 1 + n
+				continue
+			}
+			sb.WriteByte(c)
+			i++
+		case c == '[':
+			if text, n, ok := linkText(s[i:]); ok {
+				sb.WriteString(plainInline(text))
+				i += n
+				continue
+			}
+			sb.WriteByte(c)
+			i++
+		case c == '*' || c == '_':
+			n := len(s[i:]) - len(strings.TrimLeft(s[i:], string(c)))
+			before, _ := utf8.DecodeLastRuneInString(s[:i])
+			after, _ := utf8.DecodeRuneInString(s[i+n:])
+			if !isEmphasis(c, before, after, i == 0, i+n == len(s)) {
+				sb.WriteString(s[i : i+n])
+			}
+			i += n
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+	return sb.String()
+}
+
+// codeSpanEnd returns the index in s of the backtick run equal to fence
+// that closes a code span, or -1.
+func codeSpanEnd(s, fence string) int {
+	for i := 0; i < len(s); {
+		j := strings.Index(s[i:], fence)
+		if j < 0 {
+			return -1
+		}
+		j += i
+		run := len(s[j:]) - len(strings.TrimLeft(s[j:], "`"))
+		if run == len(fence) {
+			return j
+		}
+		i = j + run
+	}
+	return -1
+}
+
+// linkText reads "[text](target)" or "[text][ref]" at the start of s and
+// returns text and the length of the whole link.
+func linkText(s string) (string, int, bool) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			text, rest := s[1:i], s[i+1:]
+			switch {
+			case strings.HasPrefix(rest, "("):
+				if end := strings.IndexByte(rest, ')'); end >= 0 {
+					return text, i + 1 + end + 1, true
+				}
+			case strings.HasPrefix(rest, "["):
+				if end := strings.IndexByte(rest, ']'); end >= 0 {
+					return text, i + 1 + end + 1, true
+				}
+			}
+			return "", 0, false
+		}
+	}
+	return "", 0, false
+}
+
+// isEmphasis reports whether a run of c between the runes before and
+// after opens or closes emphasis. Asterisks do when they touch a word;
+// underscores only at a word boundary.
+func isEmphasis(c byte, before, after rune, atStart, atEnd bool) bool {
+	space := func(r rune, edge bool) bool { return edge || unicode.IsSpace(r) }
+	opens := !space(after, atEnd)
+	closes := !space(before, atStart)
+	if c == '*' {
+		return opens || closes
+	}
+	word := func(r rune, edge bool) bool { return !edge && (unicode.IsLetter(r) || unicode.IsDigit(r)) }
+	return (opens && !word(before, atStart)) || (closes && !word(after, atEnd))
+}
+
+func isASCIIPunct(c byte) bool {
+	return c < utf8.RuneSelf && unicode.IsPunct(rune(c)) || strings.IndexByte("$+<=>^`|~", c) >= 0
+}
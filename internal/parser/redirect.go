@@ -0,0 +1,174 @@
+package parser
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// AliasFileName is the alias map written next to parsed output.
+const AliasFileName = "aliases.json"
+
+// pages with a canonical link but no refresh are only treated as stubs
+// when their body is at most this many characters of text
+const stubTextLimit = 200
+
+// RedirectError is returned by Parse for redirect stubs: pages that only
+// forward the reader elsewhere with <meta http-equiv="refresh"> or
+// <link rel="canonical">. They carry no content worth indexing.
+type RedirectError struct {
+	Target string
+}
+
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("redirect stub to %s", e.Target)
+}
+
+// IsRedirect reports whether err marks a redirect stub and returns its target.
+func IsRedirect(err error) (string, bool) {
+	var re *RedirectError
+	if errors.As(err, &re) {
+		return re.Target, true
+	}
+	return "", false
+}
+
+// redirectTarget inspects the <head> of doc for a meta refresh or canonical
+// link and returns the page it points to.
+func redirectTarget(doc *html.Node) (string, bool) {
+	var refresh, canonical string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch {
+			case n.Data == "meta" && strings.EqualFold(attr(n, "http-equiv"), "refresh"):
+				refresh = refreshURL(attr(n, "content"))
+			case n.Data == "link" && hasRel(n, "canonical"):
+				canonical = attr(n, "href")
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	bodyLen := 0
+	if canonical != "" && refresh == "" {
+		bodyLen = len(strings.TrimSpace(bodyText(doc)))
+	}
+	return stubTarget(refresh, canonical, bodyLen)
+}
+
+// stubTarget decides from a page's refresh URL, canonical link and body
+// text length whether it is a redirect stub, and where it points.
+func stubTarget(refresh, canonical string, bodyLen int) (string, bool) {
+	switch {
+	case canonical != "" && refresh != "":
+		return cleanTarget(canonical), true
+	case refresh != "":
+		return cleanTarget(refresh), true
+	case canonical != "" && bodyLen <= stubTextLimit:
+		return cleanTarget(canonical), true
+	}
+	return "", false
+}
+
+// refreshURL extracts the URL from a refresh value like "0; URL=ch18-00-oop.html".
+func refreshURL(content string) string {
+	for _, part := range strings.Split(content, ";") {
+		key, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok && strings.EqualFold(strings.TrimSpace(key), "url") {
+			return strings.Trim(strings.TrimSpace(val), `"'`)
+		}
+	}
+	return ""
+}
+
+func hasRel(n *html.Node, rel string) bool {
+	for _, r := range strings.Fields(attr(n, "rel")) {
+		if strings.EqualFold(r, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+func bodyText(doc *html.Node) string {
+	if body := findFirst(doc, selector{tag: "body"}); body != nil {
+		return textContent(body)
+	}
+	return ""
+}
+
+// cleanTarget drops the fragment and query so the target names a page.
+func cleanTarget(target string) string {
+	if i := strings.IndexAny(target, "#?"); i >= 0 {
+		target = target[:i]
+	}
+	return strings.TrimPrefix(target, "./")
+}
+
+// Aliases maps the file name of a redirect stub to the page it points to,
+// e.g. "ch17-00-oop.html" -> "ch18-00-oop.html".
+type Aliases map[string]string
+
+// LoadAliases reads an alias map, returning an empty map if the file
+// does not exist yet.
+func LoadAliases(path string) (Aliases, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Aliases{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := Aliases{}
+	if err := json.Unmarshal(data, &aliases); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return aliases, nil
+}
+
+// Save writes the alias map as indented JSON.
+func (a Aliases) Save(path string) error {
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Resolve follows aliases from name to its canonical page. Only the base
+// name is looked up, so "book/ch17-00-oop.html#section" resolves like
+// "ch17-00-oop.html"; names that are not aliases come back as that base name.
+func (a Aliases) Resolve(name string) string {
+	current := path.Base(cleanTarget(name))
+	seen := map[string]bool{}
+	for !seen[current] {
+		seen[current] = true
+		next, ok := a[current]
+		if !ok {
+			return current
+		}
+		current = path.Base(next)
+	}
+	return current
+}
+
+// Names returns the alias names in sorted order.
+func (a Aliases) Names() []string {
+	names := make([]string, 0, len(a))
+	for name := range a {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
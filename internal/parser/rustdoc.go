@@ -0,0 +1,339 @@
+package parser
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// ErrNoItem is returned for rustdoc pages that document no item: source
+// views, the settings and help pages and the list of all items.
+var ErrNoItem = errors.New("rustdoc page documents no item")
+
+// Item is the API item a rustdoc page documents.
+type Item struct {
+	// Path is the full item path, e.g. "std::rc::Rc".
+	Path string `json:"path"`
+	// Kind is the item kind as rustdoc names it in the page heading:
+	// "struct", "trait", "function", "module", "type alias", ...
+	Kind string `json:"kind"`
+	// Signature is the item declaration, empty for modules and crates.
+	Signature string `json:"signature,omitempty"`
+	// Since is the Rust version the item was stabilized in.
+	Since string `json:"since,omitempty"`
+}
+
+// prefixes of the ids rustdoc gives to the members of an item
+var memberPrefixes = []string{
+	"method.", "tymethod.", "variant.", "structfield.",
+	"associatedtype.", "associatedconstant.",
+}
+
+// implementation lists that repeat the docs of other items
+var foreignImpls = map[string]bool{
+	"trait-implementations-list":     true,
+	"synthetic-implementations-list": true,
+	"blanket-implementations-list":   true,
+	"implementors-list":              true,
+	"synthetic-implementors-list":    true,
+}
+
+// rustdoc controls inside the content: section self-links, "Run" buttons
+// on examples, "Source" links and version badges, and toggle labels
+var rustdocChrome = []selector{
+	{tag: "a", classes: []string{"anchor"}},
+	{tag: "a", classes: []string{"doc-anchor"}},
+	{tag: "a", classes: []string{"test-arrow"}},
+	{classes: []string{"rightside"}},
+	{tag: "button"},
+	{tag: "summary", classes: []string{"hideme"}},
+	{tag: "rustdoc-toolbar"},
+}
+
+var duplicateID = regexp.MustCompile(`-\d+$`)
+
+// isRustdoc reports whether doc is an API page generated by rustdoc.
+// Standalone Markdown pages rendered by rustdoc, such as the stubs of the
+// first edition of the Book, lack the #main-content layout and are read
+// like any other page.
+func isRustdoc(doc *html.Node) bool {
+	meta := findFirst(doc, selector{tag: "meta", attrs: [][2]string{{"name", "generator"}}})
+	if meta == nil || attr(meta, "content") != "rustdoc" {
+		return false
+	}
+	return findFirst(doc, selector{id: "main-content"}) != nil
+}
+
+// usesRustdoc reports whether doc is extracted with the rustdoc profile.
+func usesRustdoc(doc *html.Node, opts Options) bool {
+	return opts.Profile.rustdoc(isRustdoc(doc))
+}
+
+// rustdoc reports whether the profile extracts a page as rustdoc output;
+// generated tells whether the page says it was generated by rustdoc.
+func (p Profile) rustdoc(generated bool) bool {
+	switch p {
+	case ProfileRustdoc:
+		return true
+	case ProfileMdBook, ProfileGeneric:
+		return false
+	}
+	return generated
+}
+
+// buildRustdocDocument extracts the item a rustdoc page documents. The
+// first section is headed by the item path and holds its signature and
+// doc prose, split further at the headings of the docs ("Examples",
+// "Panics"). Each method, variant, field and associated item declared on
+// the item itself follows as a section of its own, headed by its path,
+// with its signature and docs. Trait implementations are left out: their
+// docs belong to the trait. Examples are kept as Rust listings.
+func buildRustdocDocument(doc *html.Node, name string, opts Options) (*Document, error) {
+	main := findFirst(doc, selector{id: "main-content"})
+	if main == nil {
+		return nil, ErrNoItem
+	}
+	if body := findFirst(doc, selector{tag: "body"}); body != nil && (hasClass(body, "sys") || hasClass(body, "src")) {
+		return nil, ErrNoItem
+	}
+	heading := findFirst(main, selector{tag: "h1"})
+	if heading == nil {
+		return nil, ErrNoItem
+	}
+	removeMatching(main, rustdocChrome)
+
+	item := rustdocItem(doc, main, heading)
+	opts.Boilerplate.remove(main)
+
+	// lay the page out as plain HTML for the Markdown renderer
+	root := &html.Node{Type: html.ElementNode, Data: "div"}
+	appendElement(root, "h1", item.Path)
+	if item.Signature != "" {
+		root.AppendChild(codeBlock(item.Signature))
+	}
+	if top := topDocblock(main); top != nil {
+		moveChildren(root, top)
+	}
+	for _, m := range rustdocMembers(main) {
+		member := strings.TrimPrefix(m.id, m.prefix)
+		member = duplicateID.ReplaceAllString(member, "")
+		h2 := appendElement(root, "h2", item.Path+"::"+member)
+		h2.Attr = []html.Attribute{{Key: "id", Val: m.id}}
+		if sig := signatureText(m.header); sig != "" {
+			root.AppendChild(codeBlock(sig))
+		}
+		if m.docs != nil {
+			demoteHeadings(m.docs)
+			moveChildren(root, m.docs)
+		}
+	}
+
+	r := newRenderer(opts)
+	r.source = name
+	r.walk(root)
+	r.flush()
+
+	book, _, _ := strings.Cut(item.Path, "::")
+	return &Document{
+		Source:   name,
+		Title:    item.Path,
+		Book:     book,
+		Item:     &item,
+		Sections: r.sections(),
+	}, nil
+}
+
+// rustdocItem reads the item path, kind, signature and stability version
+// from the page heading and declaration.
+func rustdocItem(doc, main, heading *html.Node) Item {
+	var item Item
+
+	// "Struct <span>Rc</span>": the kind is the heading's own text
+	var kind []string
+	var name string
+	for c := heading.FirstChild; c != nil; c = c.NextSibling {
+		switch {
+		case c.Type == html.TextNode:
+			kind = append(kind, c.Data)
+		case c.Type == html.ElementNode && name == "":
+			name = strings.Join(strings.Fields(textContent(c)), "")
+		}
+	}
+	item.Kind = strings.ToLower(strings.Join(strings.Fields(strings.Join(kind, " ")), " "))
+
+	if crumbs := findFirst(main, selector{classes: []string{"rustdoc-breadcrumbs"}}); crumbs != nil && name != "" {
+		item.Path = strings.Join(strings.Fields(textContent(crumbs)), "") + "::" + name
+	} else if name != "" {
+		item.Path = name
+	}
+	if item.Path == "" {
+		// older rustdoc: "Struct std::rc::Rc" in <h1 class="fqn">
+		fields := strings.Fields(textContent(heading))
+		if len(fields) > 0 {
+			item.Path = fields[len(fields)-1]
+			item.Kind = strings.ToLower(strings.Join(fields[:len(fields)-1], " "))
+		}
+	}
+	if item.Path == "" {
+		item.Path, _ = splitTitle(pageTitle(doc))
+	}
+	// macros live in their own namespace: std::vec! next to std::vec
+	if item.Kind == "macro" && !strings.HasSuffix(item.Path, "!") {
+		item.Path += "!"
+	}
+
+	if decl := findFirst(main, selector{classes: []string{"item-decl"}}); decl != nil {
+		if pre := findFirst(decl, selector{tag: "pre"}); pre != nil {
+			decl = pre
+		}
+		item.Signature = signatureText(decl)
+	}
+	if since := findFirst(main, selector{classes: []string{"since"}}); since != nil {
+		item.Since = strings.TrimSpace(textContent(since))
+	}
+	return item
+}
+
+// topDocblock returns the item's own docs: the docblock in the
+// "Expand description" toggle, or a docblock directly in the content for
+// older rustdoc.
+func topDocblock(main *html.Node) *html.Node {
+	if top := findFirst(main, selector{tag: "details", classes: []string{"top-doc"}}); top != nil {
+		return findFirst(top, selector{classes: []string{"docblock"}})
+	}
+	for c := main.FirstChild; c != nil; c = c.NextSibling {
+		if hasClass(c, "docblock") && !hasClass(c, "item-decl") {
+			return c
+		}
+	}
+	return nil
+}
+
+// rustdocMember is a method, variant, field or associated item.
+type rustdocMember struct {
+	id, prefix string
+	// header holds the member's signature
+	header *html.Node
+	docs   *html.Node
+}
+
+// rustdocMembers collects the members declared on the item, in page order.
+func rustdocMembers(main *html.Node) []rustdocMember {
+	var members []rustdocMember
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode || foreignImpls[attr(c, "id")] {
+				continue
+			}
+			id := attr(c, "id")
+			for _, prefix := range memberPrefixes {
+				if !strings.HasPrefix(id, prefix) {
+					continue
+				}
+				header := findFirst(c, selector{classes: []string{"code-header"}})
+				if header == nil {
+					header = c
+				}
+				members = append(members, rustdocMember{id: id, prefix: prefix, header: header, docs: memberDocs(c)})
+				break
+			}
+			walk(c)
+		}
+	}
+	walk(main)
+	return members
+}
+
+// memberDocs finds the docblock of a member: inside the same toggle for
+// methods, the next sibling for variants and fields.
+func memberDocs(n *html.Node) *html.Node {
+	if n.Parent != nil && n.Parent.Data == "summary" && n.Parent.Parent != nil {
+		for c := n.Parent.Parent.FirstChild; c != nil; c = c.NextSibling {
+			if hasClass(c, "docblock") {
+				return c
+			}
+		}
+		return nil
+	}
+	for c := n.NextSibling; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode {
+			if hasClass(c, "docblock") {
+				return c
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// signatureText returns the text of a declaration, with where clauses on
+// lines of their own as rustdoc displays them.
+func signatureText(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			sb.WriteString(n.Data)
+			return
+		case n.Type == html.ElementNode && hasClass(n, "where"):
+			if s := sb.String(); s != "" && !strings.HasSuffix(s, "\n") {
+				sb.WriteString("\n")
+			}
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				walk(c)
+			}
+			sb.WriteString("\n")
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.TrimSpace(sb.String())
+}
+
+// demoteHeadings turns the headings of a member's docs into bold
+// paragraphs, so "Examples" stays in the member's section.
+func demoteHeadings(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		switch c.Data {
+		case "h1", "h2", "h3", "h4", "h5", "h6":
+			strong := &html.Node{Type: html.ElementNode, Data: "strong"}
+			moveChildren(strong, c)
+			c.Data, c.Attr = "p", nil
+			c.AppendChild(strong)
+		default:
+			demoteHeadings(c)
+		}
+	}
+}
+
+func moveChildren(dst, src *html.Node) {
+	for c := src.FirstChild; c != nil; c = src.FirstChild {
+		src.RemoveChild(c)
+		dst.AppendChild(c)
+	}
+}
+
+func appendElement(parent *html.Node, tag, text string) *html.Node {
+	n := &html.Node{Type: html.ElementNode, Data: tag}
+	n.AppendChild(&html.Node{Type: html.TextNode, Data: text})
+	parent.AppendChild(n)
+	return n
+}
+
+// codeBlock builds <pre><code class="language-rust"> around code.
+func codeBlock(code string) *html.Node {
+	pre := &html.Node{Type: html.ElementNode, Data: "pre"}
+	c := appendElement(pre, "code", code)
+	c.Attr = []html.Attribute{{Key: "class", Val: "language-rust"}}
+	return pre
+}
@@ -0,0 +1,188 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// DefaultContentSelectors are tried in order to find the article body.
+// mdBook wraps each chapter in <main>; the rest cover common static-site
+// generator layouts. When nothing matches, the whole document is used.
+var DefaultContentSelectors = []string{
+	"main",
+	"article",
+	"[role=main]",
+	"#content",
+	".content",
+}
+
+// DefaultExcludeSelectors match page chrome that is removed even when it
+// sits inside the content root: mdBook's sidebar TOC, menu bar, theme popup,
+// search bar and previous/next chapter buttons.
+var DefaultExcludeSelectors = []string{
+	"nav.sidebar",
+	"#menu-bar",
+	".theme-popup",
+	".searchbar-outer",
+	".searchresults-outer",
+	".nav-wrapper",
+	".nav-wide-wrapper",
+	".nav-chapters",
+	".mobile-nav-chapters",
+}
+
+// selector is a compound CSS selector made of an optional tag name, id,
+// classes and attributes, e.g. "nav.sidebar", "#content" or "[role=main]".
+// Combinators and pseudo-classes are not supported.
+type selector struct {
+	tag     string
+	id      string
+	classes []string
+	attrs   [][2]string
+}
+
+func parseSelector(s string) (selector, error) {
+	var sel selector
+	rest := strings.TrimSpace(s)
+	if rest == "" {
+		return sel, fmt.Errorf("empty selector")
+	}
+	if strings.ContainsAny(rest, " >+~:*,") {
+		return sel, fmt.Errorf("unsupported selector %q: only tag, #id, .class and [attr=value] are allowed", s)
+	}
+
+	// leading tag name
+	i := strings.IndexAny(rest, ".#[")
+	if i < 0 {
+		i = len(rest)
+	}
+	sel.tag = strings.ToLower(rest[:i])
+	rest = rest[i:]
+
+	for rest != "" {
+		switch rest[0] {
+		case '.', '#':
+			j := strings.IndexAny(rest[1:], ".#[")
+			if j < 0 {
+				j = len(rest) - 1
+			}
+			name := rest[1 : j+1]
+			if name == "" {
+				return sel, fmt.Errorf("invalid selector %q", s)
+			}
+			if rest[0] == '.' {
+				sel.classes = append(sel.classes, name)
+			} else {
+				sel.id = name
+			}
+			rest = rest[j+1:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return sel, fmt.Errorf("invalid selector %q: missing ]", s)
+			}
+			key, val, hasVal := strings.Cut(rest[1:end], "=")
+			if !hasVal {
+				val = "\x00" // attribute presence only
+			}
+			sel.attrs = append(sel.attrs, [2]string{strings.TrimSpace(key), strings.Trim(val, `"'`)})
+			rest = rest[end+1:]
+		default:
+			return sel, fmt.Errorf("unsupported selector %q", s)
+		}
+	}
+	return sel, nil
+}
+
+func parseSelectors(list []string) ([]selector, error) {
+	sels := make([]selector, 0, len(list))
+	for _, s := range list {
+		sel, err := parseSelector(s)
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, sel)
+	}
+	return sels, nil
+}
+
+func (sel selector) matches(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	if sel.tag != "" && sel.tag != n.Data {
+		return false
+	}
+	if sel.id != "" && attr(n, "id") != sel.id {
+		return false
+	}
+	for _, class := range sel.classes {
+		if !hasClass(n, class) {
+			return false
+		}
+	}
+	for _, kv := range sel.attrs {
+		val, ok := lookupAttr(n, kv[0])
+		if !ok || (kv[1] != "\x00" && val != kv[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+func lookupAttr(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func matchesAny(sels []selector, n *html.Node) bool {
+	for _, sel := range sels {
+		if sel.matches(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// findFirst returns the first node in document order matching sel.
+func findFirst(n *html.Node, sel selector) *html.Node {
+	if sel.matches(n) {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findFirst(c, sel); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// contentRoot picks the article body of doc: the first node matching the
+// content selectors, tried in order, or doc itself when none match.
+func contentRoot(doc *html.Node, content []selector) *html.Node {
+	for _, sel := range content {
+		if n := findFirst(doc, sel); n != nil {
+			return n
+		}
+	}
+	return doc
+}
+
+// removeMatching detaches every descendant of n matching one of sels.
+func removeMatching(n *html.Node, sels []selector) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if matchesAny(sels, c) {
+			n.RemoveChild(c)
+		} else {
+			removeMatching(c, sels)
+		}
+		c = next
+	}
+}
@@ -0,0 +1,425 @@
+package parser
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// elements that never have an end tag
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"source": true, "track": true, "wbr": true,
+}
+
+// ExtractText streams the plain text of the HTML page read from r to w with
+// html.Tokenizer, without building the document tree. The output matches
+// Parse with FormatText: the same content and exclude selectors, skipped
+// scripts, styles and hidden code lines, and tables as tab-separated rows.
+// Memory use is bounded by the largest table rather than the page size.
+//
+// r is read twice: a first pass picks the content selector (the first one
+// in the list with a match, as Parse does) and detects redirect stubs,
+// which yield a *RedirectError without writing anything, and rustdoc
+// pages, which are handed to Parse. Boilerplate
+// removal needs whole blocks and is not supported.
+func ExtractText(w io.Writer, r io.ReadSeeker, opts Options) error {
+	if opts.Boilerplate != nil {
+		return errors.New("streaming extraction does not support boilerplate removal")
+	}
+	content, exclude, err := compileSelectors(opts)
+	if err != nil {
+		return err
+	}
+
+	scan, err := scanPage(r, content, exclude)
+	if err != nil {
+		return err
+	}
+	if target, ok := stubTarget(scan.refresh, scan.canonical, scan.bodyLen); ok {
+		return &RedirectError{Target: target}
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// rustdoc pages are structured around their item and are parsed
+	// whole; they are small compared to single-page book exports
+	if opts.Profile.rustdoc(scan.rustdoc) {
+		text, err := Parse(r, opts)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, text)
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	s := &textStream{w: bw, exclude: exclude, keepBoring: opts.KeepBoring, norm: opts.Normalization}
+	if scan.content >= 0 {
+		s.root = &content[scan.content]
+	}
+	if err := s.run(html.NewTokenizer(r)); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ExtractTextFile opens inputPath and streams its plain text to w.
+func ExtractTextFile(w io.Writer, inputPath string, opts Options) error {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return ExtractText(w, file, opts)
+}
+
+// subtree tracks whether the tokenizer is inside an element, by counting
+// the nesting of the tag that opened it.
+type subtree struct {
+	tag   string
+	depth int
+}
+
+func (t *subtree) inside() bool {
+	return t.depth > 0
+}
+
+// enter records a start tag; open starts a new subtree at this element
+// when not already inside one.
+func (t *subtree) enter(tag string, open bool) {
+	switch {
+	case t.depth > 0 && tag == t.tag:
+		t.depth++
+	case t.depth == 0 && open:
+		t.tag, t.depth = tag, 1
+	}
+}
+
+// leave records an end tag and reports whether it closed the subtree.
+func (t *subtree) leave(tag string) bool {
+	if t.depth > 0 && tag == t.tag {
+		t.depth--
+		return t.depth == 0
+	}
+	return false
+}
+
+func tokenNode(t html.Token) *html.Node {
+	return &html.Node{Type: html.ElementNode, Data: t.Data, Attr: t.Attr}
+}
+
+// pageScan is what the first pass learns about a page.
+type pageScan struct {
+	// content is the index of the content selector to use, -1 for none
+	content   int
+	refresh   string
+	canonical string
+	// bodyLen approximates the length of the body text, up to the stub limit
+	bodyLen int
+	// rustdoc is set for API pages generated by rustdoc, as isRustdoc
+	rustdoc     bool
+	generator   string
+	mainContent bool
+}
+
+func scanPage(r io.Reader, content, exclude []selector) (pageScan, error) {
+	scan := pageScan{content: -1}
+	z := html.NewTokenizer(r)
+	var excluded, hidden subtree
+	inBody := false
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				scan.rustdoc = scan.generator == "rustdoc" && scan.mainContent
+				return scan, nil
+			}
+			return scan, z.Err()
+
+		case html.TextToken:
+			if inBody && !hidden.inside() && scan.bodyLen <= stubTextLimit {
+				if text := strings.Join(strings.Fields(string(z.Text())), " "); text != "" {
+					if scan.bodyLen > 0 {
+						scan.bodyLen++
+					}
+					scan.bodyLen += len(text)
+				}
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			n := tokenNode(t)
+			switch {
+			case t.Data == "body":
+				inBody = true
+			case t.Data == "meta" && strings.EqualFold(attr(n, "http-equiv"), "refresh"):
+				scan.refresh = refreshURL(attr(n, "content"))
+			case t.Data == "link" && hasRel(n, "canonical"):
+				scan.canonical = attr(n, "href")
+			case t.Data == "meta" && attr(n, "name") == "generator":
+				scan.generator = attr(n, "content")
+			}
+			if attr(n, "id") == "main-content" {
+				scan.mainContent = true
+			}
+
+			opens := tt == html.StartTagToken && !voidElements[t.Data]
+			if opens {
+				hidden.enter(t.Data, skippedElements[t.Data])
+			}
+			if excluded.inside() || matchesAny(exclude, n) {
+				if opens {
+					excluded.enter(t.Data, true)
+				}
+				continue
+			}
+			for i := range content {
+				if scan.content >= 0 && i >= scan.content {
+					break
+				}
+				if content[i].matches(n) {
+					scan.content = i
+					break
+				}
+			}
+
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			hidden.leave(string(name))
+			excluded.leave(string(name))
+		}
+	}
+}
+
+// textStream is the second pass: it writes the text of the content root.
+type textStream struct {
+	w          *bufio.Writer
+	exclude    []selector
+	keepBoring bool
+	norm       Normalization
+
+	// root selects the content element, nil for the whole page
+	root     *selector
+	inRoot   subtree
+	rootDone bool
+
+	// skipped covers scripts, styles, hidden code lines and excluded chrome
+	skipped subtree
+	table   *streamTable
+	// pre covers code listings, which are not normalized
+	pre subtree
+
+	// sep goes before the next word: "" at the start, " " after prose and
+	// a newline after a table
+	sep string
+}
+
+func (s *textStream) run(z *html.Tokenizer) error {
+	defer s.flushTable()
+	for !s.rootDone {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return nil
+			}
+			return z.Err()
+		case html.TextToken:
+			if s.emitting() {
+				s.text(string(z.Text()))
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			s.start(t, tt == html.StartTagToken && !voidElements[t.Data])
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			s.end(string(name))
+		}
+	}
+	return nil
+}
+
+func (s *textStream) emitting() bool {
+	return !s.skipped.inside() && (s.root == nil || s.inRoot.inside())
+}
+
+func (s *textStream) start(t html.Token, opens bool) {
+	n := tokenNode(t)
+	if s.skipped.inside() {
+		if opens {
+			s.skipped.enter(t.Data, false)
+		}
+		return
+	}
+	// the same skip rules as textExtractor, plus the chrome Parse removes
+	if t.Data == "script" || t.Data == "style" || (!s.keepBoring && isBoring(n)) || matchesAny(s.exclude, n) {
+		if opens {
+			s.skipped.enter(t.Data, true)
+		}
+		return
+	}
+
+	if s.root != nil {
+		if !s.inRoot.inside() {
+			if opens && s.root.matches(n) {
+				s.inRoot.enter(t.Data, true)
+			}
+			return
+		}
+		if opens {
+			s.inRoot.enter(t.Data, false)
+		}
+	}
+	if opens {
+		s.pre.enter(t.Data, t.Data == "pre")
+	}
+
+	switch {
+	case t.Data == "img":
+		if alt := attr(n, "alt"); alt != "" {
+			s.text(alt)
+		}
+	case s.table != nil:
+		s.table.start(t.Data, n)
+	case t.Data == "table" && opens:
+		s.table = &streamTable{depth: 1}
+	}
+}
+
+func (s *textStream) end(tag string) {
+	if s.skipped.inside() {
+		s.skipped.leave(tag)
+		return
+	}
+	if s.root != nil && !s.inRoot.inside() {
+		return
+	}
+	s.pre.leave(tag)
+	if s.table != nil && s.table.end(tag) {
+		s.flushTable()
+	}
+	if s.root != nil && s.inRoot.leave(tag) {
+		s.rootDone = true
+	}
+}
+
+func (s *textStream) text(raw string) {
+	if !s.pre.inside() {
+		raw = s.norm.Apply(raw)
+	}
+	words := strings.Fields(raw)
+	if len(words) == 0 {
+		return
+	}
+	if s.table != nil {
+		s.table.text(words)
+		return
+	}
+	for _, word := range words {
+		s.w.WriteString(s.sep)
+		s.w.WriteString(word)
+		s.sep = " "
+	}
+}
+
+func (s *textStream) flushTable() {
+	if s.table == nil {
+		return
+	}
+	tsv := gridFromRows(s.table.finish()).tsv()
+	s.table = nil
+	if tsv == "" {
+		return
+	}
+	if s.sep != "" {
+		s.w.WriteString("\n")
+	}
+	s.w.WriteString(tsv)
+	s.sep = "\n"
+}
+
+// streamTable collects the rows of a table while it is being tokenized.
+// Text of nested tables goes into the enclosing cell.
+type streamTable struct {
+	depth  int
+	inHead bool
+	rows   []tableRow
+	// allTH tracks, per row, whether every cell so far was a <th>
+	allTH []bool
+}
+
+func (t *streamTable) start(tag string, n *html.Node) {
+	if tag == "table" {
+		t.depth++
+		return
+	}
+	if t.depth > 1 {
+		return
+	}
+	switch tag {
+	case "thead":
+		t.inHead = true
+	case "tbody", "tfoot":
+		t.inHead = false
+	case "tr":
+		t.rows = append(t.rows, tableRow{header: t.inHead})
+		t.allTH = append(t.allTH, true)
+	case "td", "th":
+		if len(t.rows) == 0 {
+			t.start("tr", nil)
+		}
+		last := len(t.rows) - 1
+		t.rows[last].cells = append(t.rows[last].cells, "")
+		t.rows[last].spans = append(t.rows[last].spans, colspan(attr(n, "colspan")))
+		if tag == "td" {
+			t.allTH[last] = false
+		}
+	}
+}
+
+// end records an end tag and reports whether it closed the table.
+func (t *streamTable) end(tag string) bool {
+	switch tag {
+	case "table":
+		t.depth--
+		return t.depth == 0
+	case "thead":
+		if t.depth == 1 {
+			t.inHead = false
+		}
+	}
+	return false
+}
+
+func (t *streamTable) text(words []string) {
+	if len(t.rows) == 0 {
+		return
+	}
+	row := &t.rows[len(t.rows)-1]
+	if len(row.cells) == 0 {
+		return
+	}
+	cell := &row.cells[len(row.cells)-1]
+	for _, word := range words {
+		if *cell != "" {
+			*cell += " "
+		}
+		*cell += word
+	}
+}
+
+func (t *streamTable) finish() []tableRow {
+	for i := range t.rows {
+		t.rows[i].header = t.rows[i].header || (t.allTH[i] && len(t.rows[i].cells) > 0)
+	}
+	return t.rows
+}
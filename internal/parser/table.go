@@ -0,0 +1,243 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// maxColspan is the largest colspan HTML allows; larger values are clamped.
+const maxColspan = 1000
+
+// tableGrid is a table flattened into rows of cell text. header is nil when
+// the table has no header row. All rows are padded to the same width.
+type tableGrid struct {
+	header []string
+	rows   [][]string
+}
+
+// tableRow is one row of rendered cells with their colspans, before the
+// spans are expanded into columns.
+type tableRow struct {
+	cells  []string
+	spans  []int
+	header bool
+}
+
+// buildTable collects the rows of an HTML table, rendering each cell with
+// cell.
+func buildTable(n *html.Node, cell func(*html.Node) string) tableGrid {
+	var rows []tableRow
+	for _, tr := range tableRows(n) {
+		row := tableRow{header: isHeaderRow(tr)}
+		for _, c := range tableCells(tr) {
+			row.cells = append(row.cells, cell(c))
+			row.spans = append(row.spans, colspan(attr(c, "colspan")))
+		}
+		rows = append(rows, row)
+	}
+	return gridFromRows(rows)
+}
+
+// gridFromRows lays out table rows. A cell spanning several columns keeps
+// its text in the first column and leaves the others empty; rowspans are
+// ignored. Only the first row can be the header.
+func gridFromRows(rows []tableRow) tableGrid {
+	var grid tableGrid
+	width := 0
+	for i, r := range rows {
+		var row []string
+		for j, c := range r.cells {
+			row = append(row, c)
+			for range min(max(r.spans[j], 1), maxColspan) - 1 {
+				row = append(row, "")
+			}
+		}
+		if len(row) == 0 {
+			continue
+		}
+		width = max(width, len(row))
+		if i == 0 && r.header {
+			grid.header = row
+		} else {
+			grid.rows = append(grid.rows, row)
+		}
+	}
+
+	if grid.header != nil {
+		grid.header = padRow(grid.header, width)
+	}
+	for i := range grid.rows {
+		grid.rows[i] = padRow(grid.rows[i], width)
+	}
+	return grid
+}
+
+func colspan(val string) int {
+	span, err := strconv.Atoi(val)
+	if err != nil {
+		return 1
+	}
+	return span
+}
+
+// tableRows returns the rows of a table in document order, looking inside
+// thead, tbody and tfoot but not into nested tables.
+func tableRows(n *html.Node) []*html.Node {
+	var rows []*html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		switch c.Data {
+		case "tr":
+			rows = append(rows, c)
+		case "thead", "tbody", "tfoot":
+			rows = append(rows, tableRows(c)...)
+		}
+	}
+	return rows
+}
+
+func tableCells(tr *html.Node) []*html.Node {
+	var cells []*html.Node
+	for c := tr.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && (c.Data == "th" || c.Data == "td") {
+			cells = append(cells, c)
+		}
+	}
+	return cells
+}
+
+// isHeaderRow reports whether tr sits in a <thead> or holds only <th> cells.
+func isHeaderRow(tr *html.Node) bool {
+	if tr.Parent != nil && tr.Parent.Type == html.ElementNode && tr.Parent.Data == "thead" {
+		return true
+	}
+	cells := tableCells(tr)
+	for _, c := range cells {
+		if c.Data != "th" {
+			return false
+		}
+	}
+	return len(cells) > 0
+}
+
+func padRow(row []string, width int) []string {
+	for len(row) < width {
+		row = append(row, "")
+	}
+	return row
+}
+
+// markdown renders the grid as a GFM table. Tables without a header row
+// get an empty one, since GFM requires it.
+func (g tableGrid) markdown() string {
+	if len(g.rows) == 0 && g.header == nil {
+		return ""
+	}
+	header := g.header
+	if header == nil {
+		header = make([]string, len(g.rows[0]))
+	}
+
+	lines := []string{markdownRow(header)}
+	delim := make([]string, len(header))
+	for i := range delim {
+		delim[i] = "---"
+	}
+	lines = append(lines, markdownRow(delim))
+	for _, row := range g.rows {
+		lines = append(lines, markdownRow(row))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func markdownRow(cells []string) string {
+	escaped := make([]string, len(cells))
+	for i, c := range cells {
+		escaped[i] = strings.ReplaceAll(c, "|", `\|`)
+	}
+	return "| " + strings.Join(escaped, " | ") + " |"
+}
+
+// tsv renders the grid as tab-separated lines, header first.
+func (g tableGrid) tsv() string {
+	var lines []string
+	if g.header != nil {
+		lines = append(lines, tsvRow(g.header))
+	}
+	for _, row := range g.rows {
+		lines = append(lines, tsvRow(row))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func tsvRow(cells []string) string {
+	clean := make([]string, len(cells))
+	for i, c := range cells {
+		clean[i] = strings.Join(strings.Fields(c), " ")
+	}
+	return strings.Join(clean, "\t")
+}
+
+var (
+	mdTableDelimiter = regexp.MustCompile(`^\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?$`)
+	mdCodeSpan       = regexp.MustCompile("(`+)\\s?(.*?)\\s?(`+)")
+)
+
+// parseMarkdownTable reads a GFM table block back into a grid, so text
+// output can render Markdown documents' tables as TSV. It returns false if
+// block is not a table. Code span backticks are dropped from the cells.
+func parseMarkdownTable(block string) (tableGrid, bool) {
+	lines := strings.Split(strings.TrimSpace(block), "\n")
+	if len(lines) < 2 || !mdTableDelimiter.MatchString(strings.TrimSpace(lines[1])) {
+		return tableGrid{}, false
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), "|") {
+			return tableGrid{}, false
+		}
+	}
+
+	var grid tableGrid
+	header := splitMarkdownRow(lines[0])
+	if strings.Join(header, "") != "" {
+		grid.header = header
+	}
+	for _, line := range lines[2:] {
+		grid.rows = append(grid.rows, splitMarkdownRow(line))
+	}
+	return grid, true
+}
+
+func splitMarkdownRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, cell.String())
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	cells = append(cells, cell.String())
+
+	for i, c := range cells {
+		cells[i] = strings.TrimSpace(mdCodeSpan.ReplaceAllString(c, "$2"))
+	}
+	return cells
+}
@@ -0,0 +1,188 @@
+// Package snippet finds the terms of a search query in a result and shows
+// the part of it where they occur, with the terms highlighted.
+package snippet
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Style is the text put around each highlighted term.
+type Style struct {
+	Open, Close string
+}
+
+var (
+	// ANSI highlights in bold yellow, for terminals.
+	ANSI = Style{"\x1b[1;33m", "\x1b[0m"}
+	// Plain marks terms with brackets, for pipes and files.
+	Plain = Style{"[[", "]]"}
+)
+
+// words too common in questions to be worth highlighting
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "can": true, "do": true, "does": true, "for": true,
+	"from": true, "how": true, "i": true, "in": true, "is": true, "it": true,
+	"of": true, "on": true, "or": true, "the": true, "to": true, "what": true,
+	"when": true, "where": true, "which": true, "who": true, "why": true,
+	"with": true,
+}
+
+// suffixes removed from query words, so "borrowing" also finds "borrow"
+// and "borrowed"
+var suffixes = []string{"ing", "ed", "es", "s"}
+
+// minTermLength is the length in runes of the shortest term: single
+// letters, such as the x of a coordinate, would light up every word that
+// starts with them, while two-letter names like Rc and fn are kept.
+const minTermLength = 2
+
+// apostrophes joins words in the Book's contractions and possessives,
+// typed either way: "Rust's" and "Rust’s".
+const apostrophes = "'’"
+
+// word is a letter, digit or underscore; the regexp classes match any
+// script, unlike \w and \b, which are ASCII only
+const word = `\p{L}\p{N}_`
+
+// Matcher finds the terms of a query: its words other than stop words,
+// matched case-insensitively at the start of a word, along with any
+// ending, e.g. "lifetimes" matches "lifetime" and "Lifetimes".
+type Matcher struct {
+	re *regexp.Regexp
+}
+
+// NewMatcher returns the matcher for query. A query of stop words only
+// matches nothing.
+func NewMatcher(query string) *Matcher {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, w := range strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && !strings.ContainsRune(apostrophes, r)
+	}) {
+		// "Rust's" is a term for "Rust", which matches it with its ending
+		w = strings.Trim(w, apostrophes)
+		for _, a := range apostrophes {
+			w = strings.TrimSuffix(w, string(a)+"s")
+		}
+		if stopWords[w] || utf8.RuneCountInString(w) < minTermLength {
+			continue
+		}
+		for _, s := range suffixes {
+			if len(w) > len(s)+2 && strings.HasSuffix(w, s) {
+				w = strings.TrimSuffix(w, s)
+				break
+			}
+		}
+		if !seen[w] {
+			seen[w] = true
+			quoted := regexp.QuoteMeta(w)
+			for _, a := range apostrophes {
+				quoted = strings.ReplaceAll(quoted, string(a), "["+apostrophes+"]")
+			}
+			terms = append(terms, quoted)
+		}
+	}
+	if len(terms) == 0 {
+		return &Matcher{}
+	}
+	// group 1 is the start of text or the character before the word,
+	// group 2 the word: a term and any ending, apostrophes included
+	return &Matcher{re: regexp.MustCompile(`(?i)(^|[^` + word + `])((?:` + strings.Join(terms, "|") + `)[` + word + `]*(?:[` + apostrophes + `][` + word + `]+)*)`)}
+}
+
+// matches returns the byte ranges of the terms in text.
+func (m *Matcher) matches(text string) [][]int {
+	if m.re == nil {
+		return nil
+	}
+	var out [][]int
+	for _, loc := range m.re.FindAllStringSubmatchIndex(text, -1) {
+		out = append(out, loc[4:6])
+	}
+	return out
+}
+
+// Highlight returns text with every term wrapped in style.
+func (m *Matcher) Highlight(text string, style Style) string {
+	if m.re == nil {
+		return text
+	}
+	return m.re.ReplaceAllString(text, "${1}"+style.Open+"${2}"+style.Close)
+}
+
+// Snippet returns about width bytes of text, on one line, around the
+// place where the most terms occur close together, with the terms
+// highlighted and "…" where text was cut. Without any term in text, it is
+// the start of text.
+func (m *Matcher) Snippet(text string, width int, style Style) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) <= width {
+		return m.Highlight(text, style)
+	}
+
+	// the window starting at the match followed by the most others
+	start, best := 0, 0
+	matches := m.matches(text)
+	for i, a := range matches {
+		n := 0
+		for _, b := range matches[i:] {
+			if b[1] > a[0]+width {
+				break
+			}
+			n++
+		}
+		if n > best {
+			start, best = a[0], n
+		}
+	}
+	// lead into the first match with a few words of context, and keep the
+	// window full near the end of text
+	if start > 0 {
+		start -= width / 5
+	}
+	start = wordStart(text, max(0, min(start, len(text)-width)))
+	end := min(len(text), start+width)
+	if end < len(text) {
+		end = wordEnd(text, start, end)
+	}
+
+	out := m.Highlight(text[start:end], style)
+	if start > 0 {
+		out = "…" + out
+	}
+	if end < len(text) {
+		out += "…"
+	}
+	return out
+}
+
+// wordStart moves i forward to the start of a word, unless it is at one,
+// or else to a rune boundary.
+func wordStart(text string, i int) int {
+	if i == 0 || text[i-1] == ' ' {
+		return i
+	}
+	if j := strings.IndexByte(text[i:], ' '); j >= 0 {
+		return i + j + 1
+	}
+	for i < len(text) && !utf8.RuneStart(text[i]) {
+		i++
+	}
+	return i
+}
+
+// wordEnd moves end back to the end of a word after start, or else to a
+// rune boundary.
+func wordEnd(text string, start, end int) int {
+	if j := strings.LastIndexByte(text[start:end], ' '); j > 0 {
+		return start + j
+	}
+	for end > start && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	return end
+}
@@ -0,0 +1,100 @@
+package snippet_test
+
+import (
+	"strings"
+	"testing"
+
+	"ruborag/internal/snippet"
+)
+
+// ScopeText is part of ch04-01 as ruborag parse writes it.
+const ScopeText = `Now that we’re past basic Rust syntax, we won’t include all the fn main() { code in examples. ` +
+	`As a first example of ownership, we’ll look at the scope of some variables. ` +
+	`A scope is the range within a program for which an item is valid. ` +
+	"Take the following variable:\n\n    let s = \"hello\";\n\n" +
+	`The variable s refers to a string literal, where the value of the string is hardcoded into the text of our program. ` +
+	`The variable is valid from the point at which it’s declared until the end of the current scope.`
+
+func TestHighlight(t *testing.T) {
+	m := snippet.NewMatcher("What is the scope of a variable?")
+	got := m.Highlight("A scope is the range. Variables and scopes.", snippet.Plain)
+	want := "A [[scope]] is the range. [[Variables]] and [[scopes]]."
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	// endings are dropped from query words
+	m = snippet.NewMatcher("borrowing rules")
+	if got := m.Highlight("borrow, borrowed, Borrowing, rule", snippet.ANSI); strings.Count(got, snippet.ANSI.Open) != 4 {
+		t.Fatalf("expected four highlights, got %q", got)
+	}
+
+	// terms match at the start of words only
+	if got := snippet.NewMatcher("own").Highlight("known owner", snippet.Plain); got != "known [[owner]]" {
+		t.Fatalf("unexpected highlight %q", got)
+	}
+	if got := snippet.NewMatcher("what is it").Highlight("it is", snippet.Plain); got != "it is" {
+		t.Fatalf("expected stop words not to be highlighted, got %q", got)
+	}
+
+	// single letters are not terms, two-letter names are
+	if got := snippet.NewMatcher("x y Rc").Highlight("x and y in an Rc", snippet.Plain); got != "x and y in an [[Rc]]" {
+		t.Fatalf("unexpected highlight %q", got)
+	}
+
+	// a possessive is the word it belongs to, whichever apostrophe is typed
+	for _, query := range []string{"Rust's rules", "Rust’s rules"} {
+		m := snippet.NewMatcher(query)
+		got := m.Highlight("Rust’s strings and Rust's rules", snippet.Plain)
+		if want := "[[Rust’s]] strings and [[Rust's]] [[rules]]"; got != want {
+			t.Fatalf("%s: expected %q, got %q", query, want, got)
+		}
+	}
+	if got := snippet.NewMatcher("we’re").Highlight("we're done", snippet.Plain); got != "[[we're]] done" {
+		t.Fatalf("expected a contraction to match with either apostrophe, got %q", got)
+	}
+
+	// word boundaries are not ASCII only
+	if got := snippet.NewMatcher("élan").Highlight("with élan, Élan!", snippet.Plain); got != "with [[élan]], [[Élan]]!" {
+		t.Fatalf("unexpected highlight %q", got)
+	}
+	if got := snippet.NewMatcher("lan").Highlight("élan", snippet.Plain); got != "élan" {
+		t.Fatalf("expected no match inside a word, got %q", got)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	m := snippet.NewMatcher("string literal value")
+	got := m.Snippet(ScopeText, 120, snippet.Plain)
+
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") {
+		t.Fatalf("expected a snippet cut on both sides, got %q", got)
+	}
+	plain := strings.NewReplacer("[[", "", "]]", "", "…", "").Replace(got)
+	if len(plain) > 120 || strings.Contains(plain, "\n") || strings.Contains(plain, "  ") {
+		t.Fatalf("expected at most 120 bytes on one line, got %q", plain)
+	}
+	if !strings.Contains(got, "a [[string]] [[literal]], where the [[value]]") {
+		t.Fatalf("expected the snippet around the matches, got %q", got)
+	}
+	if strings.HasPrefix(plain, " ") || strings.HasSuffix(plain, " ") {
+		t.Fatalf("expected the snippet cut between words, got %q", got)
+	}
+
+	// without a match, the snippet is the start of the text
+	got = snippet.NewMatcher("trait objects").Snippet(ScopeText, 60, snippet.Plain)
+	if !strings.HasPrefix(got, "Now that we’re past") || !strings.HasSuffix(got, "…") {
+		t.Fatalf("expected the start of the text, got %q", got)
+	}
+
+	// a match near the end shows a full window
+	got = snippet.NewMatcher("current").Snippet(ScopeText, 80, snippet.Plain)
+	if !strings.HasSuffix(got, "the end of the [[current]] scope.") || len(got) < 70 {
+		t.Fatalf("expected the end of the text, got %q", got)
+	}
+
+	// short texts are kept whole
+	if got := m.Snippet("A string\nliteral.", 120, snippet.Plain); got != "A [[string]] [[literal]]." {
+		t.Fatalf("unexpected snippet %q", got)
+	}
+}
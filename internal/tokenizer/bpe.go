@@ -0,0 +1,102 @@
+package tokenizer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// BPE counts tokens with a byte pair encoding vocabulary, as OpenAI's
+// tiktoken does: the text is split into words, and the bytes of each word
+// are merged pairwise, lowest rank first, into the tokens of the
+// vocabulary.
+type BPE struct {
+	ranks map[string]int
+}
+
+// pieces approximates the pre-tokenization of the cl100k_base encoding:
+// contractions, words with the one character before them, runs of up to
+// three digits, punctuation with a leading space, and whitespace. Go's
+// regexp has no lookahead, so a run of spaces is not split before a word.
+var pieces = regexp.MustCompile(`(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+`)
+
+// LoadBPE reads a vocabulary in tiktoken's format, such as
+// cl100k_base.tiktoken: one token per line, base64-encoded, followed by a
+// space and its merge rank.
+func LoadBPE(path string) (*BPE, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		token, rank, ok := strings.Cut(text, " ")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: want a token and a rank", path, line)
+		}
+		b, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		r, err := strconv.Atoi(rank)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		ranks[string(b)] = r
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(ranks) == 0 {
+		return nil, fmt.Errorf("%s: empty vocabulary", path)
+	}
+	return &BPE{ranks: ranks}, nil
+}
+
+func (b *BPE) Count(text string) int {
+	n := 0
+	for _, piece := range pieces.FindAllString(text, -1) {
+		if _, ok := b.ranks[piece]; ok {
+			n++
+			continue
+		}
+		n += b.merge(piece)
+	}
+	return n
+}
+
+// merge returns the number of tokens piece is encoded as: starting from
+// single bytes, the adjacent pair that forms the lowest-ranked token is
+// merged until no pair is in the vocabulary.
+func (b *BPE) merge(piece string) int {
+	// bounds[i] is the byte offset where part i starts
+	bounds := make([]int, len(piece)+1)
+	for i := range bounds {
+		bounds[i] = i
+	}
+	for len(bounds) > 2 {
+		best, bestRank := -1, 0
+		for i := 0; i+2 < len(bounds); i++ {
+			rank, ok := b.ranks[piece[bounds[i]:bounds[i+2]]]
+			if ok && (best < 0 || rank < bestRank) {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		bounds = append(bounds[:best+1], bounds[best+2:]...)
+	}
+	return len(bounds) - 1
+}
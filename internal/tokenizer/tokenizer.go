@@ -0,0 +1,105 @@
+// Package tokenizer counts the tokens of a text, the unit in which
+// embedding models and LLMs limit their input, so chunks and prompts can be
+// sized to fit.
+package tokenizer
+
+import (
+	"sort"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Counter counts the tokens of a text.
+type Counter interface {
+	Count(text string) int
+}
+
+// Load returns the BPE counter for the vocabulary file at path, or the
+// Heuristic when path is empty.
+func Load(path string) (Counter, error) {
+	if path == "" {
+		return Heuristic{}, nil
+	}
+	bpe, err := LoadBPE(path)
+	if err != nil {
+		return nil, err
+	}
+	return bpe, nil
+}
+
+// Heuristic estimates token counts without a vocabulary. A word costs one
+// token per four letters or digits, every punctuation or symbol character
+// costs one, and so does every character of a script written without
+// spaces, such as Chinese. Whitespace is free, as BPE vocabularies attach
+// it to the following word. Code, with its brackets, operators and short
+// identifiers, comes out far denser than prose, as it does with a real
+// tokenizer; the estimate errs on the high side for both.
+type Heuristic struct{}
+
+func (Heuristic) Count(text string) int {
+	n, word := 0, 0
+	flush := func() {
+		n += (word + 3) / 4
+		word = 0
+	}
+	for _, r := range text {
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Thai):
+			flush()
+			n++
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r):
+			word++
+		default:
+			flush()
+			n++
+		}
+	}
+	flush()
+	return n
+}
+
+// Runes counts characters, one token per rune.
+type Runes struct{}
+
+func (Runes) Count(text string) int {
+	return utf8.RuneCountInString(text)
+}
+
+// Truncate returns the longest prefix of text, cut at a rune boundary, that
+// counts at most budget tokens, e.g. to fit retrieved passages into a
+// prompt. It is text itself when that fits.
+func Truncate(c Counter, text string, budget int) string {
+	// counts only grow with the prefix: double the prefix until it no
+	// longer fits, then search the rune boundaries between the last two
+	lo, hi := 0, runeStart(text, 64)
+	for c.Count(text[:hi]) <= budget {
+		if hi == len(text) {
+			return text
+		}
+		lo, hi = hi, runeStart(text, 2*hi)
+	}
+	var ends []int
+	for i := range text[lo:hi] {
+		ends = append(ends, lo+i)
+	}
+	n := sort.Search(len(ends), func(i int) bool {
+		return c.Count(text[:ends[i]]) > budget
+	})
+	if n == 0 {
+		return ""
+	}
+	return text[:ends[n-1]]
+}
+
+// runeStart returns the first rune boundary of text at or after i.
+func runeStart(text string, i int) int {
+	if i >= len(text) {
+		return len(text)
+	}
+	for i < len(text) && !utf8.RuneStart(text[i]) {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,125 @@
+package tokenizer_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ruborag/internal/tokenizer"
+)
+
+func TestHeuristic(t *testing.T) {
+	var h tokenizer.Heuristic
+	for _, tt := range []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"   \n", 0},
+		{"Rust", 1},
+		{"ownership", 3},
+		{"the owner", 3},
+		{"s.len()", 5},
+		{"所有权", 3},
+	} {
+		if got := h.Count(tt.text); got != tt.want {
+			t.Errorf("Count(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+
+	// the same number of characters of code costs more than prose
+	prose := "Each value in Rust has an owner, and there is only one at a time."
+	code := `fn main() { let s = String::from("hi"); takes(&s[..1]); }`
+	if h.Count(code) <= h.Count(prose) {
+		t.Fatalf("expected code (%d tokens) to count more than prose (%d tokens)", h.Count(code), h.Count(prose))
+	}
+}
+
+// writeVocab writes tokens, ranked in order, in tiktoken's format.
+func writeVocab(t *testing.T, tokens ...string) string {
+	t.Helper()
+	var b strings.Builder
+	for rank, token := range tokens {
+		fmt.Fprintf(&b, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), rank)
+	}
+	path := filepath.Join(t.TempDir(), "test.tiktoken")
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBPE(t *testing.T) {
+	path := writeVocab(t, "a", "b", "c", " ", "ab", "abc", " abc")
+	bpe, err := tokenizer.LoadBPE(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		text string
+		want int
+	}{
+		{"abc", 1},
+		{" abc", 1},
+		// "ab" merges first, then "abc", leaving the second "ab"
+		{"abcab", 2},
+		// no pair of "cba" is in the vocabulary
+		{"cba", 3},
+		{"abc abc", 2},
+		{"abc, abc", 3},
+	} {
+		if got := bpe.Count(tt.text); got != tt.want {
+			t.Errorf("Count(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+
+	if _, err := tokenizer.LoadBPE(writeVocab(t)); err == nil {
+		t.Fatal("expected an error for an empty vocabulary")
+	}
+	bad := filepath.Join(t.TempDir(), "bad.tiktoken")
+	if err := os.WriteFile(bad, []byte("YQ== one\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokenizer.LoadBPE(bad); err == nil || !strings.Contains(err.Error(), ":1:") {
+		t.Fatalf("expected an error naming line 1, got %v", err)
+	}
+
+	c, err := tokenizer.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.(*tokenizer.BPE); !ok {
+		t.Fatalf("expected a BPE counter, got %T", c)
+	}
+	if c, _ := tokenizer.Load(""); c != (tokenizer.Heuristic{}) {
+		t.Fatalf("expected the heuristic without a vocabulary, got %T", c)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	var h tokenizer.Heuristic
+	text := strings.Repeat("Ownership is a set of rules. ", 20)
+	for _, budget := range []int{0, 1, 7, 50} {
+		got := tokenizer.Truncate(h, text, budget)
+		if !strings.HasPrefix(text, got) {
+			t.Fatalf("budget %d: %q is not a prefix", budget, got)
+		}
+		if n := h.Count(got); n > budget {
+			t.Fatalf("budget %d: prefix counts %d tokens", budget, n)
+		}
+		if next := text[:len(got)+1]; h.Count(next) <= budget {
+			t.Fatalf("budget %d: %q could have taken another character", budget, got)
+		}
+	}
+	if got := tokenizer.Truncate(h, text, 1000); got != text {
+		t.Fatal("expected text that fits to be returned whole")
+	}
+
+	// cuts never split a rune
+	if got := tokenizer.Truncate(tokenizer.Runes{}, "所有权规则", 2); got != "所有" {
+		t.Fatalf("expected two runes, got %q", got)
+	}
+}